@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"github.com/dotcloud/docker/archive"
 	"github.com/dotcloud/docker/auth"
+	"github.com/dotcloud/docker/registry"
 	"github.com/dotcloud/docker/utils"
 	"github.com/gorilla/mux"
 	"io"
@@ -19,7 +20,6 @@ import (
 	"net/http"
 	"net/http/pprof"
 	"os"
-	"os/exec"
 	"regexp"
 	"strconv"
 	"strings"
@@ -126,7 +126,7 @@ func postAuth(srv *Server, version float64, w http.ResponseWriter, r *http.Reque
 	if err != nil {
 		return err
 	}
-	status, err := auth.Login(authConfig, srv.HTTPRequestFactory(nil))
+	status, err := auth.Login(authConfig, srv.HTTPRequestFactory(nil), registry.NewHTTPClient())
 	if err != nil {
 		return err
 	}
@@ -191,7 +191,7 @@ func getImagesJSON(srv *Server, version float64, w http.ResponseWriter, r *http.
 	}
 	filter := r.Form.Get("filter")
 
-	outs, err := srv.Images(all, filter)
+	outs, err := srv.Images(all, filter, r.Form["label"])
 	if err != nil {
 		return err
 	}
@@ -307,6 +307,28 @@ func getContainersChanges(srv *Server, version float64, w http.ResponseWriter, r
 	return writeJSON(w, http.StatusOK, changesStr)
 }
 
+func getContainersDiff(srv *Server, version float64, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := parseForm(r); err != nil {
+		return err
+	}
+	changes, err := srv.ContainersDiff(r.Form.Get("from"), r.Form.Get("to"))
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, changes)
+}
+
+func getImagesDiff(srv *Server, version float64, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := parseForm(r); err != nil {
+		return err
+	}
+	changes, err := srv.ImagesDiff(r.Form.Get("from"), r.Form.Get("to"))
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, changes)
+}
+
 func getContainersTop(srv *Server, version float64, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if version < 1.4 {
 		return fmt.Errorf("top was improved a lot since 1.3, Please upgrade your docker client.")
@@ -343,7 +365,7 @@ func getContainersJSON(srv *Server, version float64, w http.ResponseWriter, r *h
 		n = -1
 	}
 
-	outs := srv.Containers(all, size, n, since, before)
+	outs := srv.Containers(all, size, n, since, before, r.Form["label"])
 
 	if version < 1.5 {
 		outs2 := []APIContainersOld{}
@@ -378,6 +400,25 @@ func postImagesTag(srv *Server, version float64, w http.ResponseWriter, r *http.
 	return nil
 }
 
+func postImagesSquash(srv *Server, version float64, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := parseForm(r); err != nil {
+		return err
+	}
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	out := &APIID{}
+	job := srv.Eng.Job("squash", vars["name"])
+	job.Setenv("from", r.Form.Get("from"))
+	job.Setenv("repo", r.Form.Get("repo"))
+	job.Setenv("tag", r.Form.Get("tag"))
+	job.Stdout.AddString(&out.ID)
+	if err := job.Run(); err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusCreated, out)
+}
+
 func postCommit(srv *Server, version float64, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if err := parseForm(r); err != nil {
 		return err
@@ -527,7 +568,7 @@ func postImagesPush(srv *Server, version float64, w http.ResponseWriter, r *http
 		w.Header().Set("Content-Type", "application/json")
 	}
 	sf := utils.NewStreamFormatter(version > 1.0)
-	if err := srv.ImagePush(name, w, sf, authConfig, metaHeaders); err != nil {
+	if err := srv.ImagePush(name, r.Form.Get("tag"), w, sf, authConfig, metaHeaders); err != nil {
 		if sf.Used() {
 			w.Write(sf.FormatError(err))
 			return nil
@@ -538,15 +579,43 @@ func postImagesPush(srv *Server, version float64, w http.ResponseWriter, r *http
 }
 
 func getImagesGet(srv *Server, version float64, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
-	name := vars["name"]
+	if err := parseForm(r); err != nil {
+		return err
+	}
+	var names []string
+	if name, exists := vars["name"]; exists {
+		names = append(names, name)
+	}
+	names = append(names, r.Form["names"]...)
+	if len(names) == 0 {
+		return fmt.Errorf("Bad parameter: at least one image name is required")
+	}
 	if version > 1.0 {
 		w.Header().Set("Content-Type", "application/x-tar")
 	}
-	return srv.ImageExport(name, w)
+	return srv.ImageExport(names, w)
 }
 
 func postImagesLoad(srv *Server, version float64, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
-	return srv.ImageLoad(r.Body)
+	if err := parseForm(r); err != nil {
+		return err
+	}
+	force, err := getBoolParam(r.Form.Get("force"))
+	if err != nil {
+		return err
+	}
+	if version > 1.7 {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	sf := utils.NewStreamFormatter(version > 1.7)
+	if err := srv.ImageLoad(r.Body, force, w, sf); err != nil {
+		if sf.Used() {
+			w.Write(sf.FormatError(err))
+			return nil
+		}
+		return err
+	}
+	return nil
 }
 
 func postContainersCreate(srv *Server, version float64, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
@@ -905,15 +974,21 @@ func postBuild(srv *Server, version float64, w http.ResponseWriter, r *http.Requ
 	rawSuppressOutput := r.FormValue("q")
 	rawNoCache := r.FormValue("nocache")
 	rawRm := r.FormValue("rm")
+	dockerfileName := r.FormValue("dockerfile")
+	rawBuildArgs := r.FormValue("buildargs")
 	repoName, tag := utils.ParseRepositoryTag(repoName)
 
-	var context io.Reader
+	var (
+		context   io.Reader
+		gitCommit string
+	)
 
 	if remoteURL == "" {
 		context = r.Body
 	} else if utils.IsGIT(remoteURL) {
-		if !strings.HasPrefix(remoteURL, "git://") {
-			remoteURL = "https://" + remoteURL
+		gitURL, ref, subdir := utils.ParseGitURL(remoteURL)
+		if !strings.Contains(gitURL, "://") && !strings.HasPrefix(gitURL, "git@") {
+			gitURL = "https://" + gitURL
 		}
 		root, err := ioutil.TempDir("", "docker-build-git")
 		if err != nil {
@@ -921,11 +996,15 @@ func postBuild(srv *Server, version float64, w http.ResponseWriter, r *http.Requ
 		}
 		defer os.RemoveAll(root)
 
-		if output, err := exec.Command("git", "clone", remoteURL, root).CombinedOutput(); err != nil {
-			return fmt.Errorf("Error trying to use git: %s (%s)", err, output)
+		if gitCommit, err = utils.GitClone(gitURL, ref, root); err != nil {
+			return err
+		}
+		contextDir, err := gitContextDir(root, subdir)
+		if err != nil {
+			return err
 		}
 
-		c, err := archive.Tar(root, archive.Bzip2)
+		c, err := archive.Tar(contextDir, archive.Bzip2)
 		if err != nil {
 			return err
 		}
@@ -959,21 +1038,33 @@ func postBuild(srv *Server, version float64, w http.ResponseWriter, r *http.Requ
 	if err != nil {
 		return err
 	}
+	var buildArgs map[string]string
+	if rawBuildArgs != "" {
+		if err := json.Unmarshal([]byte(rawBuildArgs), &buildArgs); err != nil {
+			return fmt.Errorf("Bad parameter buildargs: %s", err)
+		}
+	}
 
 	if version >= 1.8 {
 		w.Header().Set("Content-Type", "application/json")
 	}
 	sf := utils.NewStreamFormatter(version >= 1.8)
+	stdout := &StdoutFormater{
+		Writer:          utils.NewWriteFlusher(w),
+		StreamFormatter: sf,
+	}
+	comment := ""
+	if gitCommit != "" {
+		fmt.Fprintf(stdout, "Building %s at commit %s\n", remoteURL, gitCommit)
+		comment = fmt.Sprintf("Built from %s at commit %s", remoteURL, gitCommit)
+	}
 	b := NewBuildFile(srv,
-		&StdoutFormater{
-			Writer:          utils.NewWriteFlusher(w),
-			StreamFormatter: sf,
-		},
+		stdout,
 		&StderrFormater{
 			Writer:          utils.NewWriteFlusher(w),
 			StreamFormatter: sf,
 		},
-		!suppressOutput, !noCache, rm, utils.NewWriteFlusher(w), sf)
+		!suppressOutput, !noCache, rm, dockerfileName, buildArgs, comment, utils.NewWriteFlusher(w), sf)
 	id, err := b.Build(context)
 	if err != nil {
 		if sf.Used() {
@@ -983,11 +1074,19 @@ func postBuild(srv *Server, version float64, w http.ResponseWriter, r *http.Requ
 		return fmt.Errorf("Error build: %s", err)
 	}
 	if repoName != "" {
-		srv.runtime.repositories.Set(repoName, tag, id, false)
+		srv.runtime.repositories.Set(repoName, tag, id, true)
 	}
 	return nil
 }
 
+func postBuildValidate(srv *Server, version float64, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	diagnostics, err := LintDockerfile(r.Body)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, diagnostics)
+}
+
 func postContainersCopy(srv *Server, version float64, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if vars == nil {
 		return fmt.Errorf("Missing parameter")
@@ -1086,11 +1185,14 @@ func createRouter(srv *Server, logging bool) (*mux.Router, error) {
 			"/images/json":                    getImagesJSON,
 			"/images/viz":                     getImagesViz,
 			"/images/search":                  getImagesSearch,
+			"/images/diff":                    getImagesDiff,
+			"/images/get":                     getImagesGet,
 			"/images/{name:.*}/get":           getImagesGet,
 			"/images/{name:.*}/history":       getImagesHistory,
 			"/images/{name:.*}/json":          getImagesByName,
 			"/containers/ps":                  getContainersJSON,
 			"/containers/json":                getContainersJSON,
+			"/containers/diff":                getContainersDiff,
 			"/containers/{name:.*}/export":    getContainersExport,
 			"/containers/{name:.*}/changes":   getContainersChanges,
 			"/containers/{name:.*}/json":      getContainersByName,
@@ -1101,11 +1203,13 @@ func createRouter(srv *Server, logging bool) (*mux.Router, error) {
 			"/auth":                         postAuth,
 			"/commit":                       postCommit,
 			"/build":                        postBuild,
+			"/build/validate":               postBuildValidate,
 			"/images/create":                postImagesCreate,
 			"/images/{name:.*}/insert":      postImagesInsert,
 			"/images/load":                  postImagesLoad,
 			"/images/{name:.*}/push":        postImagesPush,
 			"/images/{name:.*}/tag":         postImagesTag,
+			"/images/{name:.*}/squash":      postImagesSquash,
 			"/containers/create":            postContainersCreate,
 			"/containers/{name:.*}/kill":    postContainersKill,
 			"/containers/{name:.*}/restart": postContainersRestart,
@@ -1,6 +1,9 @@
 package docker
 
-import "strings"
+import (
+	"github.com/dotcloud/docker/archive"
+	"strings"
+)
 
 type (
 	APIHistory struct {
@@ -14,10 +17,12 @@ type (
 	APIImages struct {
 		ID          string   `json:"Id"`
 		RepoTags    []string `json:",omitempty"`
+		RepoDigests []string `json:",omitempty"`
 		Created     int64
 		Size        int64
 		VirtualSize int64
-		ParentId    string `json:",omitempty"`
+		ParentId    string            `json:",omitempty"`
+		Labels      map[string]string `json:",omitempty"`
 	}
 
 	APIImagesOld struct {
@@ -44,6 +49,13 @@ type (
 		NEventsListener    int         `json:",omitempty"`
 		KernelVersion      string      `json:",omitempty"`
 		IndexServerAddress string      `json:",omitempty"`
+		RegistryMirrors    [][2]string `json:",omitempty"`
+	}
+
+	APIChange struct {
+		Path      string
+		Kind      archive.ChangeType
+		SizeDelta int64
 	}
 
 	APITop struct {
@@ -66,6 +78,7 @@ type (
 		SizeRw     int64
 		SizeRootFs int64
 		Names      []string
+		Labels     map[string]string `json:",omitempty"`
 	}
 
 	APIContainersOld struct {
@@ -109,6 +122,13 @@ type (
 		Status string
 	}
 
+	APIDiagnostic struct {
+		Line     int
+		Column   int
+		Severity string
+		Message  string
+	}
+
 	APIImageConfig struct {
 		ID string `json:"Id"`
 		*Config
@@ -2,7 +2,10 @@ package archive
 
 import (
 	"archive/tar"
+	"bufio"
 	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
 	"fmt"
 	"github.com/dotcloud/docker/utils"
 	"io"
@@ -11,6 +14,7 @@ import (
 	"os/exec"
 	"path"
 	"path/filepath"
+	"strings"
 )
 
 type Archive io.Reader
@@ -59,6 +63,30 @@ func DetectCompression(source []byte) Compression {
 	return Uncompressed
 }
 
+// DecompressStream returns the uncompressed content of `archive`, which may
+// be compressed with any of the algorithms supported by Untar.
+func DecompressStream(archive io.Reader) (io.Reader, error) {
+	buf := bufio.NewReader(archive)
+	bs, err := buf.Peek(10)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	switch compression := DetectCompression(bs); compression {
+	case Uncompressed:
+		return buf, nil
+	case Gzip:
+		return gzip.NewReader(buf)
+	case Bzip2:
+		return bzip2.NewReader(buf), nil
+	case Xz:
+		cmd := exec.Command("xz", "-d", "-c", "-q")
+		cmd.Stdin = buf
+		return CmdStream(cmd, nil, nil)
+	default:
+		return nil, fmt.Errorf("Unsupported compression format %s", compression.Extension())
+	}
+}
+
 func (compression *Compression) Flag() string {
 	switch *compression {
 	case Bzip2:
@@ -110,19 +138,40 @@ func escapeName(name string) string {
 // Tar creates an archive from the directory at `path`, only including files whose relative
 // paths are included in `filter`. If `filter` is nil, then all files are included.
 func TarFilter(path string, options *TarOptions) (io.Reader, error) {
-	args := []string{"tar", "--numeric-owner", "-f", "-", "-C", path, "-T", "-"}
+	args := []string{"tar", "--numeric-owner", "-f", "-", "-c" + options.Compression.Flag()}
 	if options.Includes == nil {
 		options.Includes = []string{"."}
 	}
-	args = append(args, "-c"+options.Compression.Flag())
 
-	for _, exclude := range options.Excludes {
-		args = append(args, fmt.Sprintf("--exclude=%s", exclude))
+	// The excludes only apply to the files listed after them. They are
+	// given in a file as there may be too many of them for the command line.
+	excludesFile := ""
+	if len(options.Excludes) > 0 {
+		f, err := ioutil.TempFile("", "docker-tar-excludes")
+		if err != nil {
+			return nil, err
+		}
+		excludesFile = f.Name()
+		_, err = f.WriteString(strings.Join(options.Excludes, "\n") + "\n")
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(excludesFile)
+			return nil, err
+		}
+		args = append(args, "-X", excludesFile)
+	}
+	cleanup := func() {
+		if excludesFile != "" {
+			os.Remove(excludesFile)
+		}
 	}
 
 	if !options.Recursive {
 		args = append(args, "--no-recursion")
 	}
+	args = append(args, "-C", path, "-T", "-")
 
 	files := ""
 	for _, f := range options.Includes {
@@ -135,6 +184,7 @@ func TarFilter(path string, options *TarOptions) (io.Reader, error) {
 		var err error // Can't use := here or we override the outer tmpDir
 		tmpDir, err = ioutil.TempDir("", "docker-tar")
 		if err != nil {
+			cleanup()
 			return nil, err
 		}
 
@@ -143,10 +193,12 @@ func TarFilter(path string, options *TarOptions) (io.Reader, error) {
 			path := filepath.Join(tmpDir, f)
 			err := os.MkdirAll(filepath.Dir(path), 0600)
 			if err != nil {
+				cleanup()
 				return nil, err
 			}
 
 			if file, err := os.OpenFile(path, os.O_CREATE, 0600); err != nil {
+				cleanup()
 				return nil, err
 			} else {
 				file.Close()
@@ -159,6 +211,7 @@ func TarFilter(path string, options *TarOptions) (io.Reader, error) {
 		if tmpDir != "" {
 			_ = os.RemoveAll(tmpDir)
 		}
+		cleanup()
 	})
 }
 
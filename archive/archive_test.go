@@ -116,3 +116,45 @@ func TestTarUntar(t *testing.T) {
 		}
 	}
 }
+
+func TestDecompressStream(t *testing.T) {
+	origin, err := ioutil.TempDir("", "docker-test-decompress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(origin)
+	if err := ioutil.WriteFile(path.Join(origin, "1"), []byte("hello world"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	uncompressed, err := Tar(origin, Uncompressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := ioutil.ReadAll(uncompressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []Compression{
+		Uncompressed,
+		Gzip,
+		Bzip2,
+		Xz,
+	} {
+		compressed, err := Tar(origin, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decompressed, err := DecompressStream(compressed)
+		if err != nil {
+			t.Fatalf("Error decompressing %s: %s", c.Extension(), err)
+		}
+		content, err := ioutil.ReadAll(decompressed)
+		if err != nil {
+			t.Fatalf("Error decompressing %s: %s", c.Extension(), err)
+		}
+		if !bytes.Equal(content, expected) {
+			t.Fatalf("Wrong content after decompressing %s", c.Extension())
+		}
+	}
+}
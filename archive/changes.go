@@ -294,6 +294,36 @@ func ChangesSize(newDir string, changes []Change) int64 {
 	return size
 }
 
+// SizeDelta returns by how many bytes `change` grew the filesystem when
+// going from oldDir to newDir. Directories count for nothing, except the
+// deleted ones: their content is not listed in the changes, so they count
+// for everything they contained.
+func (change *Change) SizeDelta(newDir, oldDir string) int64 {
+	if change.Kind == ChangeDelete {
+		return -treeSize(filepath.Join(oldDir, change.Path))
+	}
+	return fileSize(filepath.Join(newDir, change.Path)) - fileSize(filepath.Join(oldDir, change.Path))
+}
+
+func fileSize(path string) int64 {
+	fileInfo, err := os.Lstat(path)
+	if err != nil || fileInfo.IsDir() {
+		return 0
+	}
+	return fileInfo.Size()
+}
+
+func treeSize(root string) int64 {
+	var size int64
+	filepath.Walk(root, func(path string, f os.FileInfo, err error) error {
+		if err == nil && !f.IsDir() {
+			size += f.Size()
+		}
+		return nil
+	})
+	return size
+}
+
 func ExportChanges(dir string, changes []Change) (Archive, error) {
 	files := make([]string, 0)
 	deletions := make([]string, 0)
@@ -257,6 +257,51 @@ func TestChangesDirsMutated(t *testing.T) {
 	}
 }
 
+func TestChangesSizeDelta(t *testing.T) {
+	src, err := ioutil.TempDir("", "docker-changes-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+	dst, err := ioutil.TempDir("", "docker-changes-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+
+	for _, dir := range []string{src, dst} {
+		if err := ioutil.WriteFile(path.Join(dir, "file1"), []byte("1234"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(path.Join(src, "dir1"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(src, "dir1", "file1-1"), []byte("12345"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(dst, "file1"), []byte("12"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(dst, "filenew"), []byte("123"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[Change]int64{
+		{"/file1", ChangeModify}:   -2,
+		{"/filenew", ChangeAdd}:    3,
+		{"/dir1", ChangeDelete}:    -5,
+		{"/missing", ChangeAdd}:    0,
+		{"/dir1", ChangeModify}:    0,
+		{"/filenew", ChangeModify}: 3,
+	}
+	for change, delta := range expected {
+		if d := change.SizeDelta(dst, src); d != delta {
+			t.Fatalf("Wrong size delta for %s, expected %d, got %d", change.String(), delta, d)
+		}
+	}
+}
+
 func TestApplyLayer(t *testing.T) {
 	t.Skip("Skipping TestApplyLayer due to known failures") // Disable this for now as it is broken
 	return
@@ -0,0 +1,163 @@
+package archive
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ReadExcludes reads the patterns of the .dockerignore file of the directory
+// `dir`, one per line. Empty lines and comments are skipped, and a pattern
+// starting with '!' re-includes what the previous ones excluded. It returns
+// no pattern if there is no .dockerignore.
+func ReadExcludes(dir string) ([]string, error) {
+	f, err := os.Open(filepath.Join(dir, ".dockerignore"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		pattern := strings.TrimSpace(scanner.Text())
+		if pattern == "" || pattern[0] == '#' {
+			continue
+		}
+		negation := ""
+		if pattern[0] == '!' {
+			negation, pattern = "!", pattern[1:]
+		}
+		pattern = strings.TrimPrefix(filepath.Clean(pattern), "/")
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("Invalid .dockerignore pattern %q: %s", pattern, err)
+		}
+		patterns = append(patterns, negation+pattern)
+	}
+	return patterns, scanner.Err()
+}
+
+// Excluded returns whether the relative path `name` is excluded by the
+// .dockerignore `patterns`. A pattern matching a directory matches all the
+// files in it, and the last pattern matching `name` wins.
+func Excluded(name string, patterns []string) (bool, error) {
+	name = filepath.Clean(name)
+	excluded := false
+	for _, pattern := range patterns {
+		negation := strings.HasPrefix(pattern, "!")
+		pattern = strings.TrimPrefix(pattern, "!")
+
+		matched := false
+		for parent := name; parent != "." && parent != "/"; parent = filepath.Dir(parent) {
+			ok, err := filepath.Match(pattern, parent)
+			if err != nil {
+				return false, err
+			}
+			if ok {
+				matched = true
+				break
+			}
+		}
+		if matched {
+			excluded = !negation
+		}
+	}
+	return excluded, nil
+}
+
+// ExcludePatterns walks the directory `dir` and returns the TarOptions
+// Excludes leaving out its files excluded by the .dockerignore `patterns`,
+// but the files of `keep`. The patterns and the files to keep are relative
+// to `root`, which contains `dir`.
+func ExcludePatterns(root, dir string, patterns, keep []string) ([]string, error) {
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+	negations := false
+	for _, pattern := range patterns {
+		if strings.HasPrefix(pattern, "!") {
+			negations = true
+		}
+	}
+	kept := make(map[string]bool, len(keep))
+	for _, name := range keep {
+		kept[filepath.Clean(name)] = true
+	}
+	excludes, _, err := excludePatterns(root, dir, ".", patterns, negations, kept)
+	return excludes, err
+}
+
+// excludePatterns returns the excludes of the subdirectory `name` of `dir`,
+// and whether any of its files is kept. The excluded directories are only
+// walked if a negation or a file to keep may re-include some of their files.
+func excludePatterns(root, dir, name string, patterns []string, negations bool, keep map[string]bool) ([]string, bool, error) {
+	fis, err := ioutil.ReadDir(filepath.Join(dir, name))
+	if err != nil {
+		return nil, false, err
+	}
+	var (
+		excludes []string
+		kept     bool
+	)
+	for _, fi := range fis {
+		child := filepath.Join(name, fi.Name())
+		rel, err := filepath.Rel(root, filepath.Join(dir, child))
+		if err != nil {
+			return nil, false, err
+		}
+		excluded := false
+		if !keep[rel] {
+			if excluded, err = Excluded(rel, patterns); err != nil {
+				return nil, false, err
+			}
+		}
+		if fi.IsDir() && (!excluded || negations || keepsFileIn(keep, rel)) {
+			childExcludes, childKept, err := excludePatterns(root, dir, child, patterns, negations, keep)
+			if err != nil {
+				return nil, false, err
+			}
+			if !excluded || childKept {
+				excludes = append(excludes, childExcludes...)
+				kept = true
+				continue
+			}
+		}
+		if excluded {
+			excludes = append(excludes, tarPattern(child))
+		} else {
+			kept = true
+		}
+	}
+	return excludes, kept, nil
+}
+
+// keepsFileIn returns whether one of the files of `keep` is in the
+// directory `dir`.
+func keepsFileIn(keep map[string]bool, dir string) bool {
+	for name := range keep {
+		if strings.HasPrefix(name, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// tarPattern escapes the wildcards of the relative path `name` so that tar
+// only excludes this exact member.
+func tarPattern(name string) string {
+	escaped := make([]byte, 0, len(name)+2)
+	for _, c := range []byte("./" + name) {
+		switch c {
+		case '*', '?', '[', '\\':
+			escaped = append(escaped, '\\')
+		}
+		escaped = append(escaped, c)
+	}
+	return string(escaped)
+}
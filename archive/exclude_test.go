@@ -0,0 +1,143 @@
+package archive
+
+import (
+	"github.com/dotcloud/tar"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestExcluded(t *testing.T) {
+	patterns := []string{"*.log", "data", "build/*", "!build/keep", "!important.log"}
+	for name, expected := range map[string]bool{
+		"foo.log":          true,
+		"important.log":    false,
+		"src/foo.log":      false,
+		"data":             true,
+		"data/big/file":    true,
+		"database":         false,
+		"src/data":         false,
+		"build/output":     true,
+		"build/keep":       false,
+		"build/keep/file":  false,
+		"build":            false,
+		"Dockerfile":       false,
+		"./data/file":      true,
+		"src/../data/file": true,
+	} {
+		excluded, err := Excluded(name, patterns)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if excluded != expected {
+			t.Errorf("Expected %s excluded to be %v", name, expected)
+		}
+	}
+
+	if _, err := Excluded("foo", []string{"[-"}); err == nil {
+		t.Fatal("Expected an error for an invalid pattern")
+	}
+}
+
+func TestReadExcludes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "docker-test-dockerignore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if patterns, err := ReadExcludes(dir); err != nil || patterns != nil {
+		t.Fatalf("Expected no pattern without a .dockerignore, got %v (%v)", patterns, err)
+	}
+
+	if err := ioutil.WriteFile(path.Join(dir, ".dockerignore"), []byte("# comment\n.git\n\n/data/ \n!data/keep\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	patterns, err := ReadExcludes(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(patterns, " ") != ".git data !data/keep" {
+		t.Fatalf("Unexpected patterns %v", patterns)
+	}
+
+	if err := ioutil.WriteFile(path.Join(dir, ".dockerignore"), []byte("[-\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadExcludes(dir); err == nil {
+		t.Fatal("Expected an error for an invalid pattern")
+	}
+}
+
+func TestExcludePatterns(t *testing.T) {
+	root, err := ioutil.TempDir("", "docker-test-exclude")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	for _, name := range []string{
+		"Dockerfile",
+		".git/config",
+		"data/big",
+		"data/keep/file",
+		"logs/a.log",
+		"src/main.go",
+		"src/main.log",
+		"src/we*ird",
+		"src/weXird",
+	} {
+		if err := os.MkdirAll(path.Join(root, path.Dir(name)), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path.Join(root, name), []byte(name), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	patterns := []string{".git", "data", "!data/keep", "*/*.log", "src/we\\*ird"}
+
+	for _, c := range []struct {
+		dir      string
+		expected []string
+	}{
+		{root, []string{"./", "./Dockerfile", "./data/", "./data/keep/", "./data/keep/file", "./logs/", "./src/", "./src/main.go", "./src/weXird"}},
+		{path.Join(root, "src"), []string{"./", "./main.go", "./weXird"}},
+	} {
+		excludes, err := ExcludePatterns(root, c.dir, patterns, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		archive, err := TarFilter(c.dir, &TarOptions{Recursive: true, Compression: Uncompressed, Excludes: excludes})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		tr := tar.NewReader(archive)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			names = append(names, hdr.Name)
+		}
+		sort.Strings(names)
+		if strings.Join(names, " ") != strings.Join(c.expected, " ") {
+			t.Fatalf("Expected the files %v in the archive of %s, got %v", c.expected, c.dir, names)
+		}
+	}
+
+	// The files to keep don't make the other excluded files walked
+	excludes, err := ExcludePatterns(root, root, []string{"data", "Dockerfile"}, []string{"Dockerfile", "data/keep/file"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"./data/big"}; strings.Join(excludes, " ") != strings.Join(expected, " ") {
+		t.Fatalf("Expected the excludes %v, got %v", expected, excludes)
+	}
+}
@@ -31,6 +31,9 @@ type AuthConfig struct {
 	Auth          string `json:"auth"`
 	Email         string `json:"email"`
 	ServerAddress string `json:"serveraddress,omitempty"`
+	// CredsHelper is the name of the credentials helper storing the
+	// username and password, instead of the config file
+	CredsHelper string `json:"credsHelper,omitempty"`
 }
 
 type ConfigFile struct {
@@ -107,6 +110,10 @@ func LoadConfig(rootPath string) (*ConfigFile, error) {
 		configFile.Configs[IndexServerAddress()] = authConfig
 	} else {
 		for k, authConfig := range configFile.Configs {
+			if authConfig.CredsHelper != "" {
+				// The credentials are fetched from the helper when needed
+				continue
+			}
 			authConfig.Username, authConfig.Password, err = decodeAuth(authConfig.Auth)
 			if err != nil {
 				return &configFile, err
@@ -131,7 +138,16 @@ func SaveConfig(configFile *ConfigFile) error {
 	for k, authConfig := range configFile.Configs {
 		authCopy := authConfig
 
-		authCopy.Auth = encodeAuth(&authCopy)
+		if authCopy.CredsHelper != "" {
+			if authCopy.Password != "" {
+				if err := storeCredentials(authCopy.CredsHelper, k, authCopy.Username, authCopy.Password); err != nil {
+					return err
+				}
+			}
+			authCopy.Auth = ""
+		} else {
+			authCopy.Auth = encodeAuth(&authCopy)
+		}
 		authCopy.Username = ""
 		authCopy.Password = ""
 		authCopy.ServerAddress = ""
@@ -149,9 +165,42 @@ func SaveConfig(configFile *ConfigFile) error {
 	return nil
 }
 
-// try to register/login to the registry server
-func Login(authConfig *AuthConfig, factory *utils.HTTPRequestFactory) (string, error) {
-	client := &http.Client{}
+// Forget removes the credentials of a registry. If they are stored by a
+// helper, they are erased from it, and the registry keeps using the helper.
+func (config *ConfigFile) Forget(serverAddress string) error {
+	authConfig, exists := config.Configs[serverAddress]
+	if !exists {
+		return nil
+	}
+	if authConfig.CredsHelper == "" {
+		delete(config.Configs, serverAddress)
+		return nil
+	}
+	config.Configs[serverAddress] = AuthConfig{Email: authConfig.Email, CredsHelper: authConfig.CredsHelper}
+	return eraseCredentials(authConfig.CredsHelper, serverAddress)
+}
+
+// withCredentials fills in the username and password of authConfig from
+// its credentials helper, if it has one.
+func withCredentials(serverAddress string, authConfig AuthConfig) AuthConfig {
+	if authConfig.CredsHelper == "" || authConfig.Password != "" {
+		return authConfig
+	}
+	username, password, err := getCredentials(authConfig.CredsHelper, serverAddress)
+	if err != nil {
+		utils.Debugf("%s", err)
+		return authConfig
+	}
+	authConfig.Username = username
+	authConfig.Password = password
+	return authConfig
+}
+
+// try to register/login to the registry server, with client if it is not nil
+func Login(authConfig *AuthConfig, factory *utils.HTTPRequestFactory, client *http.Client) (string, error) {
+	if client == nil {
+		client = &http.Client{}
+	}
 	reqStatusCode := 0
 	var status string
 	var reqBody []byte
@@ -233,7 +282,7 @@ func Login(authConfig *AuthConfig, factory *utils.HTTPRequestFactory) (string, e
 func (config *ConfigFile) ResolveAuthConfig(registry string) AuthConfig {
 	if registry == IndexServerAddress() || len(registry) == 0 {
 		// default to the index server
-		return config.Configs[IndexServerAddress()]
+		return withCredentials(IndexServerAddress(), config.Configs[IndexServerAddress()])
 	}
 	// if its not the index server there are three cases:
 	//
@@ -256,12 +305,12 @@ func (config *ConfigFile) ResolveAuthConfig(registry string) AuthConfig {
 
 	resolveIgnoringProtocol := func(url string) AuthConfig {
 		if c, found := config.Configs[url]; found {
-			return c
+			return withCredentials(url, c)
 		}
 		registrySwappedProtocol := swapProtocol(url)
 		// now try to match with the different protocol
 		if c, found := config.Configs[registrySwappedProtocol]; found {
-			return c
+			return withCredentials(registrySwappedProtocol, c)
 		}
 		return AuthConfig{}
 	}
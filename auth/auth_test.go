@@ -3,6 +3,8 @@ package auth
 import (
 	"io/ioutil"
 	"os"
+	"path"
+	"strings"
 	"testing"
 )
 
@@ -140,3 +142,72 @@ func TestResolveAuthConfigFullURL(t *testing.T) {
 		}
 	}
 }
+
+func TestCredentialsHelper(t *testing.T) {
+	root, err := ioutil.TempDir("", "docker-test-auth-helper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	// A helper keeping the last stored credentials in a file
+	helper := `#!/bin/sh
+case "$1" in
+store) cat > "$(dirname "$0")/creds" ;;
+get) cat "$(dirname "$0")/creds" 2>/dev/null || { echo "credentials not found"; exit 1; } ;;
+erase) rm -f "$(dirname "$0")/creds" ;;
+*) echo "unknown action $1" >&2; exit 1 ;;
+esac
+`
+	if err := ioutil.WriteFile(path.Join(root, "docker-credential-test"), []byte(helper), 0700); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", root+":"+os.Getenv("PATH"))
+
+	registry := "https://registry.example.com/v1/"
+	configFile := &ConfigFile{rootPath: root, Configs: make(map[string]AuthConfig)}
+	configFile.Configs[registry] = AuthConfig{
+		Username:    "docker-user",
+		Password:    "docker-pass",
+		Email:       "docker@docker.io",
+		CredsHelper: "test",
+	}
+	if err := SaveConfig(configFile); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(path.Join(root, CONFIGFILE))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), encodeAuth(&AuthConfig{Username: "docker-user", Password: "docker-pass"})) {
+		t.Fatalf("Expected the credentials not to be in the config file: %s", b)
+	}
+
+	configFile, err = LoadConfig(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if configFile.Configs[registry].Password != "" {
+		t.Fatal("Expected the password to be fetched only when needed")
+	}
+	authConfig := configFile.ResolveAuthConfig("registry.example.com")
+	if authConfig.Username != "docker-user" || authConfig.Password != "docker-pass" {
+		t.Fatalf("Expected the credentials from the helper, got %s:%s", authConfig.Username, authConfig.Password)
+	}
+	if authConfig.Email != "docker@docker.io" {
+		t.Fatalf("Expected the email from the config file, got %s", authConfig.Email)
+	}
+
+	if err := configFile.Forget(registry); err != nil {
+		t.Fatal(err)
+	}
+	authConfig = configFile.ResolveAuthConfig("registry.example.com")
+	if authConfig.Username != "" || authConfig.CredsHelper != "test" {
+		t.Fatalf("Expected the credentials to be erased from the helper, got %+v", authConfig)
+	}
+
+	if _, err := runCredentialsHelper("test", "list", nil); err == nil || err.Error() != "Credentials helper test failed to list: unknown action list" {
+		t.Fatalf("Expected the error of the helper on stderr, got %v", err)
+	}
+}
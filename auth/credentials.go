@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// A credentials helper keeps the credentials of a registry out of the
+// config file. It is an executable named docker-credential-<name>, called
+// with the action as argument:
+//
+//	store: reads {"ServerURL", "Username", "Secret"} on stdin
+//	get:   reads the server address on stdin, writes {"Username", "Secret"}
+//	erase: reads the server address on stdin
+const credentialsHelperPrefix = "docker-credential-"
+
+type helperCredentials struct {
+	ServerURL string `json:",omitempty"`
+	Username  string
+	Secret    string
+}
+
+func runCredentialsHelper(helper, action string, input []byte) ([]byte, error) {
+	cmd := exec.Command(credentialsHelperPrefix+helper, action)
+	cmd.Stdin = bytes.NewReader(input)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	output, err := cmd.Output()
+	if err != nil {
+		msg := strings.TrimSpace(string(output))
+		if stderr.Len() > 0 {
+			msg = strings.TrimSpace(stderr.String())
+		}
+		if msg == "" {
+			msg = err.Error()
+		}
+		return nil, fmt.Errorf("Credentials helper %s failed to %s: %s", helper, action, msg)
+	}
+	return output, nil
+}
+
+func getCredentials(helper, serverAddress string) (string, string, error) {
+	output, err := runCredentialsHelper(helper, "get", []byte(serverAddress))
+	if err != nil {
+		return "", "", err
+	}
+	var creds helperCredentials
+	if err := json.Unmarshal(output, &creds); err != nil {
+		return "", "", fmt.Errorf("Credentials helper %s returned invalid credentials: %s", helper, err)
+	}
+	return creds.Username, creds.Secret, nil
+}
+
+func storeCredentials(helper, serverAddress, username, password string) error {
+	input, err := json.Marshal(&helperCredentials{
+		ServerURL: serverAddress,
+		Username:  username,
+		Secret:    password,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = runCredentialsHelper(helper, "store", input)
+	return err
+}
+
+func eraseCredentials(helper, serverAddress string) error {
+	_, err := runCredentialsHelper(helper, "erase", []byte(serverAddress))
+	return err
+}
@@ -1,18 +1,23 @@
 package docker
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"github.com/dotcloud/docker/archive"
+	"github.com/dotcloud/docker/dockerfile"
 	"github.com/dotcloud/docker/utils"
 	"io"
 	"io/ioutil"
 	"net/url"
 	"os"
 	"path"
-	"reflect"
+	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type BuildFile interface {
@@ -29,17 +34,41 @@ type buildFile struct {
 	maintainer   string
 	config       *Config
 	context      string
+	excludes     []string
 	verbose      bool
 	utilizeCache bool
 	rm           bool
 
+	dockerfileName string
+
+	// The comment of the built image
+	comment string
+
+	// The build arguments given by the user, and the ones declared by ARG
+	// with their values: in the current stage, and before the first FROM
+	// for the FROM lines
+	buildArgs map[string]string
+	args      map[string]string
+	fromArgs  map[string]string
+
+	// The names of all the build arguments declared by ARG
+	declaredArgs map[string]struct{}
+
+	// The current step, for the build events
+	step int
+
+	// The images of the previous stages, and the indexes of the named ones
+	stages     []string
+	stageNames map[string]int
+
 	tmpContainers map[string]struct{}
 	tmpImages     map[string]struct{}
 
 	outStream io.Writer
 	errStream io.Writer
 
-	// Deprecated, original writer used for ImagePull. To be removed.
+	// Deprecated, original writer used for ImagePull and the build
+	// events. To be removed.
 	outOld io.Writer
 	sf     *utils.StreamFormatter
 }
@@ -52,11 +81,47 @@ func (b *buildFile) clearTmp(containers map[string]struct{}) {
 	}
 }
 
-func (b *buildFile) CmdFrom(name string) error {
-	image, err := b.runtime.repositories.LookupImage(name)
+func (b *buildFile) CmdFrom(args string) error {
+	// A FROM only sees the build arguments declared before the first one,
+	// not the ENV and ARG of the previous stage
+	if b.fromArgs == nil {
+		b.fromArgs = b.args
+	}
+	b.config, b.args = &Config{}, b.fromArgs
+	args, err := b.ReplaceEnvMatches(args)
+	if err != nil {
+		return err
+	}
+	b.args = make(map[string]string)
+	name, stageName := args, ""
+	if fields := strings.Fields(args); len(fields) == 3 && strings.EqualFold(fields[1], "AS") {
+		name, stageName = fields[0], strings.ToLower(fields[2])
+	} else if len(fields) != 1 {
+		return fmt.Errorf("Invalid FROM format: %s (expected FROM image [AS name])", args)
+	}
+
+	// Each FROM starts a new stage, the image of the previous one can be
+	// used by the next ones
+	if b.image != "" {
+		b.stages = append(b.stages, b.image)
+	}
+	if stageName != "" {
+		if _, exists := b.stageNames[stageName]; exists {
+			return fmt.Errorf("Duplicate build stage name: %s", stageName)
+		}
+		b.stageNames[stageName] = len(b.stages)
+	}
+	b.maintainer = ""
+
+	var image *Image
+	if index, exists := b.stageNames[strings.ToLower(name)]; exists && index < len(b.stages) {
+		image, err = b.runtime.graph.Get(b.stages[index])
+	} else {
+		image, err = b.runtime.repositories.LookupImage(name)
+	}
 	if err != nil {
 		if b.runtime.graph.IsNotExist(err) {
-			remote, tag := utils.ParseRepositoryTag(name)
+			remote, tag := utils.ParseRepositoryRef(name)
 			if err := b.srv.ImagePull(remote, tag, b.outOld, b.sf, nil, nil, true); err != nil {
 				return err
 			}
@@ -76,9 +141,58 @@ func (b *buildFile) CmdFrom(name string) error {
 	if b.config.Env == nil || len(b.config.Env) == 0 {
 		b.config.Env = append(b.config.Env, "HOME=/", "PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin")
 	}
+
+	// The triggers of the base image are not inherited by the new one
+	triggers := b.config.OnBuild
+	b.config.OnBuild = nil
+	if len(triggers) > 0 {
+		fmt.Fprintf(b.outStream, "# Executing %d build triggers\n", len(triggers))
+	}
+	for i, trigger := range triggers {
+		node, cmd, err := parseTrigger(trigger)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(b.outStream, "Trigger %d : %s\n", i+1, node)
+		if err := cmd(b, node.Value); err != nil {
+			return err
+		}
+		fmt.Fprintf(b.outStream, " ---> %s\n", utils.TruncateID(b.image))
+	}
 	return nil
 }
 
+// parseTrigger parses the instruction of an ONBUILD, which can't be an
+// ONBUILD or a FROM.
+func parseTrigger(trigger string) (*dockerfile.Node, func(*buildFile, string) error, error) {
+	nodes, err := dockerfile.Parse(strings.NewReader(trigger))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(nodes) != 1 {
+		return nil, nil, fmt.Errorf("ONBUILD requires exactly one instruction: %s", trigger)
+	}
+	node := nodes[0]
+	switch node.Instruction {
+	case "ONBUILD", "FROM":
+		return nil, nil, fmt.Errorf("%s isn't allowed as an ONBUILD trigger", node.Instruction)
+	}
+	cmd, exists := buildInstructions[node.Instruction]
+	if !exists {
+		return nil, nil, fmt.Errorf("Unknown ONBUILD trigger instruction %s", node.Instruction)
+	}
+	return node, cmd, nil
+}
+
+func (b *buildFile) CmdOnbuild(trigger string) error {
+	node, _, err := parseTrigger(trigger)
+	if err != nil {
+		return err
+	}
+	b.config.OnBuild = append(append([]string{}, b.config.OnBuild...), node.String())
+	return b.commit("", b.config.Cmd, fmt.Sprintf("ONBUILD %s", node))
+}
+
 func (b *buildFile) CmdMaintainer(name string) error {
 	b.maintainer = name
 	return b.commit("", b.config.Cmd, fmt.Sprintf("MAINTAINER %s", name))
@@ -99,25 +213,23 @@ func (b *buildFile) CmdRun(args string) error {
 
 	defer func(cmd []string) { b.config.Cmd = cmd }(cmd)
 
+	// The build arguments are only in the environment of the command, and
+	// in the config of its container to be part of the cache lookup
+	env := b.config.Env
+	b.config.Env = append(append([]string{}, env...), b.argsEnv()...)
+	defer func(env []string) { b.config.Env = env }(env)
+
 	utils.Debugf("Command to be executed: %v", b.config.Cmd)
 
-	if b.utilizeCache {
-		if cache, err := b.srv.ImageGetCached(b.image, b.config); err != nil {
-			return err
-		} else if cache != nil {
-			fmt.Fprintf(b.outStream, " ---> Using cache\n")
-			utils.Debugf("[BUILDER] Use cached version")
-			b.image = cache.ID
-			return nil
-		} else {
-			utils.Debugf("[BUILDER] Cache miss")
-		}
+	if hit, err := b.probeCache(); err != nil || hit {
+		return err
 	}
 
 	cid, err := b.run()
 	if err != nil {
 		return err
 	}
+	b.config.Env = env
 	if err := b.commit(cid, cmd, "run"); err != nil {
 		return err
 	}
@@ -145,20 +257,57 @@ func (b *buildFile) ReplaceEnvMatches(value string) (string, error) {
 		match = match[strings.Index(match, "$"):]
 		matchKey := strings.Trim(match, "${}")
 
-		for _, envVar := range b.config.Env {
-			envParts := strings.SplitN(envVar, "=", 2)
-			envKey := envParts[0]
-			envValue := envParts[1]
-
-			if envKey == matchKey {
-				value = strings.Replace(value, match, envValue, -1)
-				break
-			}
+		if envKey := b.FindEnvKey(matchKey); envKey >= 0 {
+			envValue := strings.SplitN(b.config.Env[envKey], "=", 2)[1]
+			value = strings.Replace(value, match, envValue, -1)
+		} else if argValue, exists := b.args[matchKey]; exists {
+			value = strings.Replace(value, match, argValue, -1)
 		}
 	}
 	return value, nil
 }
 
+// argsEnv returns the declared build arguments which aren't overridden by
+// an ENV, as environment variables sorted by name.
+func (b *buildFile) argsEnv() []string {
+	var env []string
+	for key, value := range b.args {
+		if b.FindEnvKey(key) < 0 {
+			env = append(env, fmt.Sprintf("%s=%s", key, value))
+		}
+	}
+	sort.Strings(env)
+	return env
+}
+
+// CmdArg declares a build argument, with an optional default value used
+// if the user doesn't give one. In a stage, an argument declared before the
+// first FROM defaults to its value there.
+func (b *buildFile) CmdArg(args string) error {
+	if strings.ContainsAny(args, " \t") {
+		return fmt.Errorf("ARG requires exactly one argument: %s", args)
+	}
+	key, value := args, ""
+	hasDefault := false
+	if parts := strings.SplitN(args, "=", 2); len(parts) == 2 {
+		key, value, hasDefault = parts[0], parts[1], true
+	}
+	if key == "" {
+		return fmt.Errorf("Invalid ARG format: %s (empty name)", args)
+	}
+	if fromValue, exists := b.fromArgs[key]; exists && !hasDefault {
+		value, hasDefault = fromValue, true
+	}
+	if buildValue, exists := b.buildArgs[key]; exists {
+		value, hasDefault = buildValue, true
+	}
+	if hasDefault {
+		b.args[key] = value
+	}
+	b.declaredArgs[key] = struct{}{}
+	return nil
+}
+
 func (b *buildFile) CmdEnv(args string) error {
 	tmp := strings.SplitN(args, " ", 2)
 	if len(tmp) != 2 {
@@ -182,6 +331,73 @@ func (b *buildFile) CmdEnv(args string) error {
 	return b.commit("", b.config.Cmd, fmt.Sprintf("ENV %s", replacedVar))
 }
 
+// splitLabels splits `key=value` pairs on whitespace. Values can be
+// double quoted to contain spaces.
+func splitLabels(args string) ([]string, error) {
+	var (
+		pairs   []string
+		current []rune
+		quoted  bool
+		escaped bool
+	)
+	for _, c := range args {
+		switch {
+		case escaped:
+			current = append(current, c)
+			escaped = false
+		case c == '\\' && quoted:
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case (c == ' ' || c == '\t') && !quoted:
+			if len(current) > 0 {
+				pairs = append(pairs, string(current))
+				current = current[:0]
+			}
+		default:
+			current = append(current, c)
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("Unterminated quote in LABEL: %s", args)
+	}
+	if len(current) > 0 {
+		pairs = append(pairs, string(current))
+	}
+	return pairs, nil
+}
+
+func (b *buildFile) CmdLabel(args string) error {
+	value, err := b.ReplaceEnvMatches(args)
+	if err != nil {
+		return err
+	}
+	pairs, err := splitLabels(value)
+	if err != nil {
+		return err
+	}
+	if len(pairs) == 0 {
+		return fmt.Errorf("LABEL requires at least one key=value pair")
+	}
+	// Copy the labels, the map may be shared with the parent image's config
+	labels := make(map[string]string, len(b.config.Labels)+len(pairs))
+	for key, value := range b.config.Labels {
+		labels[key] = value
+	}
+	for _, pair := range pairs {
+		if !strings.Contains(pair, "=") {
+			return fmt.Errorf("Invalid LABEL format: %s (expected key=value)", pair)
+		}
+		key, value := parseLabel(pair)
+		if key == "" {
+			return fmt.Errorf("Invalid LABEL format: %s (empty key)", pair)
+		}
+		labels[key] = value
+	}
+	b.config.Labels = labels
+	return b.commit("", b.config.Cmd, fmt.Sprintf("LABEL %s", strings.Join(pairs, " ")))
+}
+
 func (b *buildFile) CmdCmd(args string) error {
 	var cmd []string
 	if err := json.Unmarshal([]byte(args), &cmd); err != nil {
@@ -196,12 +412,20 @@ func (b *buildFile) CmdCmd(args string) error {
 }
 
 func (b *buildFile) CmdExpose(args string) error {
+	args, err := b.ReplaceEnvMatches(args)
+	if err != nil {
+		return err
+	}
 	ports := strings.Split(args, " ")
 	b.config.PortSpecs = append(ports, b.config.PortSpecs...)
 	return b.commit("", b.config.Cmd, fmt.Sprintf("EXPOSE %v", ports))
 }
 
 func (b *buildFile) CmdUser(args string) error {
+	args, err := b.ReplaceEnvMatches(args)
+	if err != nil {
+		return err
+	}
 	b.config.User = args
 	return b.commit("", b.config.Cmd, fmt.Sprintf("USER %v", args))
 }
@@ -210,10 +434,6 @@ func (b *buildFile) CmdInsert(args string) error {
 	return fmt.Errorf("INSERT has been deprecated. Please use ADD instead")
 }
 
-func (b *buildFile) CmdCopy(args string) error {
-	return fmt.Errorf("COPY has been deprecated. Please use ADD instead")
-}
-
 func (b *buildFile) CmdEntrypoint(args string) error {
 	if args == "" {
 		return fmt.Errorf("Entrypoint cannot be empty")
@@ -232,11 +452,19 @@ func (b *buildFile) CmdEntrypoint(args string) error {
 }
 
 func (b *buildFile) CmdWorkdir(workdir string) error {
+	workdir, err := b.ReplaceEnvMatches(workdir)
+	if err != nil {
+		return err
+	}
 	b.config.WorkingDir = workdir
 	return b.commit("", b.config.Cmd, fmt.Sprintf("WORKDIR %v", workdir))
 }
 
 func (b *buildFile) CmdVolume(args string) error {
+	args, err := b.ReplaceEnvMatches(args)
+	if err != nil {
+		return err
+	}
 	if args == "" {
 		return fmt.Errorf("Volume cannot be empty")
 	}
@@ -285,36 +513,173 @@ func (b *buildFile) addRemote(container *Container, orig, dest string) error {
 	return container.Inject(file.Body, dest)
 }
 
-func (b *buildFile) addContext(container *Container, orig, dest string) error {
+// contextPath returns the path of orig in the build context, checking
+// that it exists, isn't excluded by the .dockerignore and doesn't escape
+// the context.
+func (b *buildFile) contextPath(orig string) (string, os.FileInfo, error) {
 	origPath := path.Join(b.context, orig)
+	if !strings.HasPrefix(origPath, b.context) {
+		return "", nil, fmt.Errorf("Forbidden path outside the build context: %s (%s)", orig, origPath)
+	}
+	fi, err := os.Stat(origPath)
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: no such file or directory", orig)
+	}
+	rel, err := filepath.Rel(b.context, origPath)
+	if err != nil {
+		return "", nil, err
+	}
+	if excluded, err := archive.Excluded(rel, b.excludes); err != nil {
+		return "", nil, err
+	} else if excluded {
+		return "", nil, fmt.Errorf("%s: no such file or directory (excluded by .dockerignore)", orig)
+	}
+	return origPath, fi, nil
+}
+
+// tarContext archives the file or directory origPath of the build context,
+// leaving out the files excluded by the .dockerignore.
+func (b *buildFile) tarContext(origPath string, fi os.FileInfo) (io.Reader, error) {
+	options := &archive.TarOptions{Recursive: true, Compression: archive.Uncompressed}
+	if fi.IsDir() {
+		excludes, err := archive.ExcludePatterns(b.context, origPath, b.excludes, nil)
+		if err != nil {
+			return nil, err
+		}
+		options.Excludes = excludes
+	} else {
+		options.Includes = []string{path.Base(origPath)}
+		origPath = path.Dir(origPath)
+	}
+	return archive.TarFilter(origPath, options)
+}
+
+// checksumContext computes the TarSum of orig in the build context, which
+// covers the content and the metadata of the files.
+func (b *buildFile) checksumContext(orig string) (string, error) {
+	origPath, fi, err := b.contextPath(orig)
+	if err != nil {
+		return "", err
+	}
+	layer, err := b.tarContext(origPath, fi)
+	if err != nil {
+		return "", err
+	}
+	// The modification times are left out, or a fresh checkout of the same
+	// files, such as a git context, would never hit the cache
+	tarsum := &utils.TarSum{Reader: layer, IgnoreMtime: true}
+	if _, err := io.Copy(ioutil.Discard, tarsum); err != nil {
+		return "", err
+	}
+	return tarsum.Sum(nil), nil
+}
+
+// addContext copies orig from the build context to dest in the container.
+// If decompress is set, a file which is an archive is unpacked instead.
+func (b *buildFile) addContext(container *Container, orig, dest string, decompress bool) error {
+	origPath, fi, err := b.contextPath(orig)
+	if err != nil {
+		return err
+	}
 	destPath := path.Join(container.RootfsPath(), dest)
 	// Preserve the trailing '/'
 	if strings.HasSuffix(dest, "/") {
 		destPath = destPath + "/"
 	}
-	if !strings.HasPrefix(origPath, b.context) {
-		return fmt.Errorf("Forbidden path outside the build context: %s (%s)", orig, origPath)
+	if fi.IsDir() {
+		if err := os.MkdirAll(destPath, 0755); err != nil {
+			return err
+		}
+		layer, err := b.tarContext(origPath, fi)
+		if err != nil {
+			return err
+		}
+		return archive.Untar(layer, destPath, nil)
+	}
+	// First try to unpack the source as an archive
+	if decompress {
+		err := archive.UntarPath(origPath, destPath)
+		if err == nil {
+			return nil
+		}
+		utils.Debugf("Couldn't untar %s to %s: %s", origPath, destPath, err)
+	}
+	// If that fails, just copy it as a regular file
+	if err := os.MkdirAll(path.Dir(destPath), 0755); err != nil {
+		return err
+	}
+	return archive.CopyWithTar(origPath, destPath)
+}
+
+// addStage copies orig from the filesystem of the image of a previous stage
+// to dest in the container.
+func (b *buildFile) addStage(container *Container, stageImage, orig, dest string) error {
+	root, err := b.runtime.graph.driver.Get(stageImage)
+	if err != nil {
+		return fmt.Errorf("Driver %s failed to get image rootfs %s: %s", b.runtime.graph.driver, stageImage, err)
+	}
+	root, err = filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+	// The symlinks of the image are resolved in its filesystem, not the host's
+	origPath, err := utils.FollowSymlinkInScope(root, orig)
+	if err != nil {
+		return fmt.Errorf("%s: no such file or directory in the stage", orig)
 	}
 	fi, err := os.Stat(origPath)
 	if err != nil {
-		return fmt.Errorf("%s: no such file or directory", orig)
+		return err
+	}
+	destPath := path.Join(container.RootfsPath(), dest)
+	// Preserve the trailing '/'
+	if strings.HasSuffix(dest, "/") {
+		destPath = destPath + "/"
 	}
 	if fi.IsDir() {
-		if err := archive.CopyWithTar(origPath, destPath); err != nil {
+		if err := os.MkdirAll(destPath, 0755); err != nil {
 			return err
 		}
-		// First try to unpack the source as an archive
-	} else if err := archive.UntarPath(origPath, destPath); err != nil {
-		utils.Debugf("Couldn't untar %s to %s: %s", origPath, destPath, err)
-		// If that fails, just copy it as a regular file
-		if err := os.MkdirAll(path.Dir(destPath), 0755); err != nil {
-			return err
-		}
-		if err := archive.CopyWithTar(origPath, destPath); err != nil {
+	} else if err := os.MkdirAll(path.Dir(destPath), 0755); err != nil {
+		return err
+	}
+	return archive.CopyWithTar(origPath, destPath)
+}
+
+// addFiles runs an instruction adding files to the image with add. If
+// source identifies the content of the files, the image is looked up in
+// the build cache.
+func (b *buildFile) addFiles(instruction, orig, dest, source string, add func(*Container) error) error {
+	cmd := b.config.Cmd
+	b.config.Cmd = []string{"/bin/sh", "-c", fmt.Sprintf("#(nop) %s %s in %s", instruction, orig, dest)}
+	defer func(cmd []string) { b.config.Cmd = cmd }(cmd)
+
+	if source != "" {
+		b.config.Cmd = []string{"/bin/sh", "-c", fmt.Sprintf("#(nop) %s %s (%s) in %s", instruction, orig, source, dest)}
+
+		if hit, err := b.probeCache(); err != nil || hit {
 			return err
 		}
 	}
-	return nil
+
+	b.config.Image = b.image
+	// Create the container and start it
+	container, _, err := b.runtime.Create(b.config, "")
+	if err != nil {
+		return err
+	}
+	b.tmpContainers[container.ID] = struct{}{}
+	b.event(&utils.JSONBuildEvent{Event: "container", ContainerID: container.ID})
+
+	if err := container.EnsureMounted(); err != nil {
+		return err
+	}
+	defer container.Unmount()
+
+	if err := add(container); err != nil {
+		return err
+	}
+	return b.commit(container.ID, cmd, fmt.Sprintf("%s %s in %s", instruction, orig, dest))
 }
 
 func (b *buildFile) CmdAdd(args string) error {
@@ -336,37 +701,85 @@ func (b *buildFile) CmdAdd(args string) error {
 		return err
 	}
 
-	cmd := b.config.Cmd
-	b.config.Cmd = []string{"/bin/sh", "-c", fmt.Sprintf("#(nop) ADD %s in %s", orig, dest)}
+	// The content of a remote file is only known once downloaded, but the
+	// files of the context can be looked up in the cache by their checksum
+	if utils.IsURL(orig) {
+		return b.addFiles("ADD", orig, dest, "", func(container *Container) error {
+			return b.addRemote(container, orig, dest)
+		})
+	}
+	checksum, err := b.checksumContext(orig)
+	if err != nil {
+		return err
+	}
+	return b.addFiles("ADD", orig, dest, checksum, func(container *Container) error {
+		return b.addContext(container, orig, dest, true)
+	})
+}
 
-	b.config.Image = b.image
-	// Create the container and start it
-	container, _, err := b.runtime.Create(b.config, "")
+// CmdCopy copies files like ADD, without downloading URLs nor unpacking
+// archives. With --from, the files are copied from the image of a
+// previous stage instead of the build context.
+func (b *buildFile) CmdCopy(args string) error {
+	stage := ""
+	if strings.HasPrefix(args, "--from=") {
+		tmp := strings.SplitN(args, " ", 2)
+		if len(tmp) != 2 {
+			return fmt.Errorf("Invalid COPY format")
+		}
+		stage, args = strings.TrimPrefix(tmp[0], "--from="), strings.Trim(tmp[1], " \t")
+	}
+	tmp := strings.SplitN(args, " ", 2)
+	if len(tmp) != 2 {
+		return fmt.Errorf("Invalid COPY format")
+	}
+
+	orig, err := b.ReplaceEnvMatches(strings.Trim(tmp[0], " \t"))
 	if err != nil {
 		return err
 	}
-	b.tmpContainers[container.ID] = struct{}{}
 
-	if err := container.EnsureMounted(); err != nil {
+	dest, err := b.ReplaceEnvMatches(strings.Trim(tmp[1], " \t"))
+	if err != nil {
 		return err
 	}
-	defer container.Unmount()
 
-	if utils.IsURL(orig) {
-		if err := b.addRemote(container, orig, dest); err != nil {
-			return err
-		}
-	} else {
-		if err := b.addContext(container, orig, dest); err != nil {
+	if stage != "" {
+		stageImage, err := b.stageImage(stage)
+		if err != nil {
 			return err
 		}
+		return b.addFiles("COPY", fmt.Sprintf("--from=%s %s", stage, orig), dest, stageImage, func(container *Container) error {
+			return b.addStage(container, stageImage, orig, dest)
+		})
 	}
 
-	if err := b.commit(container.ID, cmd, fmt.Sprintf("ADD %s in %s", orig, dest)); err != nil {
+	if b.context == "" {
+		return fmt.Errorf("No context given. Impossible to use COPY")
+	}
+	checksum, err := b.checksumContext(orig)
+	if err != nil {
 		return err
 	}
-	b.config.Cmd = cmd
-	return nil
+	return b.addFiles("COPY", orig, dest, checksum, func(container *Container) error {
+		return b.addContext(container, orig, dest, false)
+	})
+}
+
+// stageImage returns the image built by a previous stage, given its name
+// or its index.
+func (b *buildFile) stageImage(stage string) (string, error) {
+	index, exists := b.stageNames[strings.ToLower(stage)]
+	if !exists {
+		var err error
+		if index, err = strconv.Atoi(stage); err != nil {
+			return "", fmt.Errorf("No such build stage: %s", stage)
+		}
+	}
+	if index < 0 || index >= len(b.stages) {
+		return "", fmt.Errorf("The build stage %s isn't a previous stage", stage)
+	}
+	return b.stages[index], nil
 }
 
 type StdoutFormater struct {
@@ -410,6 +823,7 @@ func (b *buildFile) run() (string, error) {
 	}
 	b.tmpContainers[c.ID] = struct{}{}
 	fmt.Fprintf(b.outStream, " ---> Running in %s\n", utils.TruncateID(c.ID))
+	b.event(&utils.JSONBuildEvent{Event: "container", ContainerID: c.ID})
 
 	// override the entry point that may have been picked up from the base image
 	c.Path = b.config.Cmd[0]
@@ -446,6 +860,36 @@ func (b *buildFile) run() (string, error) {
 	return c.ID, nil
 }
 
+// probeCache looks up an image built from the current image with the
+// current config. If there is one, it becomes the current image.
+func (b *buildFile) probeCache() (bool, error) {
+	if !b.utilizeCache {
+		return false, nil
+	}
+	cache, err := b.srv.ImageGetCached(b.image, b.config)
+	if err != nil {
+		return false, err
+	}
+	if cache == nil {
+		utils.Debugf("[BUILDER] Cache miss")
+		b.event(&utils.JSONBuildEvent{Event: "cache-miss"})
+		return false, nil
+	}
+	fmt.Fprintf(b.outStream, " ---> Using cache\n")
+	utils.Debugf("[BUILDER] Use cached version")
+	b.image = cache.ID
+	b.event(&utils.JSONBuildEvent{Event: "cache-hit", ImageID: cache.ID})
+	return true, nil
+}
+
+// event sends a build event to the client, for the current step.
+func (b *buildFile) event(event *utils.JSONBuildEvent) {
+	event.Step = b.step
+	if buf := b.sf.FormatBuildEvent(event); len(buf) > 0 {
+		b.outOld.Write(buf)
+	}
+}
+
 // Commit the container <id> with the autorun command <autoCmd>
 func (b *buildFile) commit(id string, autoCmd []string, comment string) error {
 	if b.image == "" {
@@ -457,17 +901,8 @@ func (b *buildFile) commit(id string, autoCmd []string, comment string) error {
 		b.config.Cmd = []string{"/bin/sh", "-c", "#(nop) " + comment}
 		defer func(cmd []string) { b.config.Cmd = cmd }(cmd)
 
-		if b.utilizeCache {
-			if cache, err := b.srv.ImageGetCached(b.image, b.config); err != nil {
-				return err
-			} else if cache != nil {
-				fmt.Fprintf(b.outStream, " ---> Using cache\n")
-				utils.Debugf("[BUILDER] Use cached version")
-				b.image = cache.ID
-				return nil
-			} else {
-				utils.Debugf("[BUILDER] Cache miss")
-			}
+		if hit, err := b.probeCache(); err != nil || hit {
+			return err
 		}
 
 		container, warnings, err := b.runtime.Create(b.config, "")
@@ -479,6 +914,7 @@ func (b *buildFile) commit(id string, autoCmd []string, comment string) error {
 		}
 		b.tmpContainers[container.ID] = struct{}{}
 		fmt.Fprintf(b.outStream, " ---> Running in %s\n", utils.TruncateID(container.ID))
+		b.event(&utils.JSONBuildEvent{Event: "container", ContainerID: container.ID})
 		id = container.ID
 		if err := container.EnsureMounted(); err != nil {
 			return err
@@ -504,8 +940,31 @@ func (b *buildFile) commit(id string, autoCmd []string, comment string) error {
 	return nil
 }
 
-// Long lines can be split with a backslash
-var lineContinuation = regexp.MustCompile(`\s*\\\s*\n`)
+// buildInstructions maps the instructions of a Dockerfile to the methods
+// executing them. It is filled in init, as FROM executes the triggers of
+// the base image with it.
+var buildInstructions map[string]func(*buildFile, string) error
+
+func init() {
+	buildInstructions = map[string]func(*buildFile, string) error{
+		"FROM":       (*buildFile).CmdFrom,
+		"ARG":        (*buildFile).CmdArg,
+		"MAINTAINER": (*buildFile).CmdMaintainer,
+		"RUN":        (*buildFile).CmdRun,
+		"ENV":        (*buildFile).CmdEnv,
+		"LABEL":      (*buildFile).CmdLabel,
+		"CMD":        (*buildFile).CmdCmd,
+		"EXPOSE":     (*buildFile).CmdExpose,
+		"USER":       (*buildFile).CmdUser,
+		"INSERT":     (*buildFile).CmdInsert,
+		"COPY":       (*buildFile).CmdCopy,
+		"ENTRYPOINT": (*buildFile).CmdEntrypoint,
+		"WORKDIR":    (*buildFile).CmdWorkdir,
+		"VOLUME":     (*buildFile).CmdVolume,
+		"ADD":        (*buildFile).CmdAdd,
+		"ONBUILD":    (*buildFile).CmdOnbuild,
+	}
+}
 
 func (b *buildFile) Build(context io.Reader) (string, error) {
 	// FIXME: @creack "name" is a terrible variable name
@@ -518,47 +977,65 @@ func (b *buildFile) Build(context io.Reader) (string, error) {
 	}
 	defer os.RemoveAll(name)
 	b.context = name
-	filename := path.Join(name, "Dockerfile")
-	if _, err := os.Stat(filename); os.IsNotExist(err) {
-		return "", fmt.Errorf("Can't build a directory with no Dockerfile")
+	if b.excludes, err = archive.ReadExcludes(name); err != nil {
+		return "", err
+	}
+	filename, err := b.dockerfilePath()
+	if err != nil {
+		return "", err
 	}
 	fileBytes, err := ioutil.ReadFile(filename)
 	if err != nil {
 		return "", err
 	}
-	dockerfile := string(fileBytes)
-	dockerfile = lineContinuation.ReplaceAllString(dockerfile, "")
-	stepN := 0
-	for _, line := range strings.Split(dockerfile, "\n") {
-		line = strings.Trim(strings.Replace(line, "\t", " ", -1), " \t\r\n")
-		// Skip comments and empty line
-		if len(line) == 0 || line[0] == '#' {
-			continue
-		}
-		tmp := strings.SplitN(line, " ", 2)
-		if len(tmp) != 2 {
-			return "", fmt.Errorf("Invalid Dockerfile format")
+	nodes, err := dockerfile.Parse(bytes.NewReader(fileBytes))
+	if err != nil {
+		if parseErr, ok := err.(*dockerfile.Error); ok {
+			parseErr.File = b.dockerfileName
 		}
-		instruction := strings.ToLower(strings.Trim(tmp[0], " "))
-		arguments := strings.Trim(tmp[1], " ")
-
-		method, exists := reflect.TypeOf(b).MethodByName("Cmd" + strings.ToUpper(instruction[:1]) + strings.ToLower(instruction[1:]))
+		return "", err
+	}
+	for _, node := range nodes {
+		cmd, exists := buildInstructions[node.Instruction]
 		if !exists {
-			fmt.Fprintf(b.errStream, "# Skipping unknown instruction %s\n", strings.ToUpper(instruction))
+			fmt.Fprintf(b.errStream, "# Skipping unknown instruction %s (line %d)\n", node.Instruction, node.StartLine)
 			continue
 		}
 
-		stepN += 1
-		fmt.Fprintf(b.outStream, "Step %d : %s %s\n", stepN, strings.ToUpper(instruction), arguments)
+		b.step += 1
+		fmt.Fprintf(b.outStream, "Step %d : %s\n", b.step, node)
+		b.event(&utils.JSONBuildEvent{Event: "step", Line: node.StartLine, Instruction: node.String()})
+		start := time.Now()
 
-		ret := method.Func.Call([]reflect.Value{reflect.ValueOf(b), reflect.ValueOf(arguments)})[0].Interface()
-		if ret != nil {
-			return "", ret.(error)
+		if err := cmd(b, node.Value); err != nil {
+			err = b.stepError(node, err)
+			event := &utils.JSONBuildEvent{Event: "error", Error: err.Error()}
+			if jsonErr, ok := err.(*utils.JSONError); ok {
+				event.Code = jsonErr.Code
+			}
+			b.event(event)
+			return "", err
 		}
 
 		fmt.Fprintf(b.outStream, " ---> %s\n", utils.TruncateID(b.image))
+		b.event(&utils.JSONBuildEvent{Event: "step-done", ImageID: b.image, Duration: time.Since(start).Seconds()})
+	}
+	var unused []string
+	for key := range b.buildArgs {
+		if _, exists := b.declaredArgs[key]; !exists {
+			unused = append(unused, key)
+		}
+	}
+	if len(unused) > 0 {
+		sort.Strings(unused)
+		fmt.Fprintf(b.outStream, " ---> [Warning] The build arguments %s are not declared by ARG\n", strings.Join(unused, ", "))
 	}
 	if b.image != "" {
+		if b.comment != "" {
+			if err := b.commitComment(); err != nil {
+				return "", err
+			}
+		}
 		fmt.Fprintf(b.outStream, "Successfully built %s\n", utils.TruncateID(b.image))
 		if b.rm {
 			b.clearTmp(b.tmpContainers)
@@ -568,19 +1045,200 @@ func (b *buildFile) Build(context io.Reader) (string, error) {
 	return "", fmt.Errorf("An error occurred during the build\n")
 }
 
-func NewBuildFile(srv *Server, outStream, errStream io.Writer, verbose, utilizeCache, rm bool, outOld io.Writer, sf *utils.StreamFormatter) BuildFile {
+// commitComment commits an empty layer with the comment of the build on top
+// of the built image. The comment isn't part of the cache lookup of the
+// steps: the image of the last step may come from another build. The layer
+// of a previous build with the same comment is reused, though.
+func (b *buildFile) commitComment() error {
+	img, err := b.runtime.graph.Get(b.image)
+	if err != nil {
+		return err
+	}
+	containerConfig := img.ContainerConfig
+	containerConfig.Cmd = []string{"/bin/sh", "-c", "#(nop) " + b.comment}
+	if b.utilizeCache {
+		cache, err := b.srv.ImageGetCached(img.ID, &containerConfig)
+		if err != nil {
+			return err
+		}
+		if cache != nil && cache.Comment == b.comment {
+			b.image = cache.ID
+			return nil
+		}
+	}
+	commented := &Image{
+		ID:              GenerateID(),
+		Parent:          img.ID,
+		Comment:         b.comment,
+		Created:         time.Now().UTC(),
+		ContainerConfig: containerConfig,
+		DockerVersion:   VERSION,
+		Author:          img.Author,
+		Config:          img.Config,
+		Architecture:    img.Architecture,
+	}
+	if err := b.runtime.graph.Register(nil, nil, commented); err != nil {
+		return err
+	}
+	b.image = commented.ID
+	return nil
+}
+
+// dockerfilePath returns the path of the Dockerfile to build, which must be
+// in the build context. The same error is returned whether the Dockerfile is
+// missing or outside of the context, so that the files of the host can't be
+// probed through the API.
+func (b *buildFile) dockerfilePath() (string, error) {
+	if b.dockerfileName == "" {
+		b.dockerfileName = "Dockerfile"
+	}
+	errLocate := fmt.Errorf("Cannot locate the Dockerfile %s in the build context", b.dockerfileName)
+	name := filepath.Clean(b.dockerfileName)
+	if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+		return "", errLocate
+	}
+	context, err := filepath.EvalSymlinks(b.context)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Lstat(filepath.Join(context, name)); os.IsNotExist(err) && name == "Dockerfile" {
+		return "", fmt.Errorf("Can't build a directory with no Dockerfile")
+	}
+	filename, err := filepath.EvalSymlinks(filepath.Join(context, name))
+	if err != nil || !strings.HasPrefix(filename, context+"/") {
+		return "", errLocate
+	}
+	return filename, nil
+}
+
+// gitContextDir returns the subdirectory `subdir` of the git repository
+// cloned in `root`, which is the build context.
+func gitContextDir(root, subdir string) (string, error) {
+	root, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+	dir, err := filepath.EvalSymlinks(filepath.Join(root, subdir))
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("Cannot locate the directory %s in the git repository", subdir)
+	} else if err != nil {
+		return "", err
+	}
+	if dir != root && !strings.HasPrefix(dir, root+"/") {
+		return "", fmt.Errorf("Forbidden path outside the git repository: %s (%s)", subdir, dir)
+	}
+	if fi, err := os.Stat(dir); err != nil {
+		return "", err
+	} else if !fi.IsDir() {
+		return "", fmt.Errorf("%s isn't a directory of the git repository", subdir)
+	}
+	return dir, nil
+}
+
+// stepError tells which line of the Dockerfile failed. The exit code of a
+// failed command is kept.
+func (b *buildFile) stepError(node *dockerfile.Node, err error) error {
+	prefix := fmt.Sprintf("%s line %d: ", b.dockerfileName, node.StartLine)
+	if jsonErr, ok := err.(*utils.JSONError); ok {
+		return &utils.JSONError{Code: jsonErr.Code, Message: prefix + jsonErr.Message}
+	}
+	return fmt.Errorf("%s%s", prefix, err)
+}
+
+// LintDockerfile reports the problems of a Dockerfile without building it:
+// the syntax error stopping the parser, or warnings for the instructions the
+// builder would skip or which are likely to fail.
+func LintDockerfile(r io.Reader) ([]APIDiagnostic, error) {
+	nodes, err := dockerfile.Parse(r)
+	if parseErr, ok := err.(*dockerfile.Error); ok {
+		return []APIDiagnostic{{
+			Line:     parseErr.Line,
+			Column:   parseErr.Column,
+			Severity: "error",
+			Message:  parseErr.Msg,
+		}}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	diagnostics := []APIDiagnostic{}
+	warn := func(node *dockerfile.Node, format string, a ...interface{}) {
+		diagnostics = append(diagnostics, APIDiagnostic{
+			Line:     node.StartLine,
+			Column:   node.Column,
+			Severity: "warning",
+			Message:  fmt.Sprintf(format, a...),
+		})
+	}
+	from := false
+	for _, node := range nodes {
+		if _, exists := buildInstructions[node.Instruction]; !exists {
+			warn(node, "Unknown instruction %s", node.Instruction)
+			continue
+		}
+		switch node.Instruction {
+		case "FROM":
+			from = true
+		case "ARG":
+			// Build arguments can be declared for the FROM
+		default:
+			if !from {
+				warn(node, "The first instruction must be FROM, found %s", node.Instruction)
+				// Don't report the following instructions
+				from = true
+			}
+		}
+
+		// The values of the variables aren't known without building
+		switch node.Instruction {
+		case "WORKDIR":
+			if !strings.HasPrefix(node.Value, "/") && !strings.HasPrefix(node.Value, "$") {
+				warn(node, "WORKDIR %s isn't an absolute path", node.Value)
+			}
+		case "ADD":
+			if orig := strings.Fields(node.Value)[0]; utils.IsURL(orig) {
+				warn(node, "ADD downloads %s without a checksum to verify it", orig)
+			}
+		case "EXPOSE":
+			for _, port := range strings.Fields(node.Value) {
+				if strings.Contains(port, "$") {
+					continue
+				}
+				if _, _, err := parsePortSpecs([]string{port}); err != nil {
+					warn(node, "Invalid port %s: %s", port, err)
+				}
+			}
+		}
+	}
+	if !from {
+		diagnostics = append(diagnostics, APIDiagnostic{
+			Line:     1,
+			Column:   1,
+			Severity: "warning",
+			Message:  "No FROM instruction",
+		})
+	}
+	return diagnostics, nil
+}
+
+func NewBuildFile(srv *Server, outStream, errStream io.Writer, verbose, utilizeCache, rm bool, dockerfileName string, buildArgs map[string]string, comment string, outOld io.Writer, sf *utils.StreamFormatter) BuildFile {
 	return &buildFile{
-		runtime:       srv.runtime,
-		srv:           srv,
-		config:        &Config{},
-		outStream:     outStream,
-		errStream:     errStream,
-		tmpContainers: make(map[string]struct{}),
-		tmpImages:     make(map[string]struct{}),
-		verbose:       verbose,
-		utilizeCache:  utilizeCache,
-		rm:            rm,
-		sf:            sf,
-		outOld:        outOld,
+		runtime:        srv.runtime,
+		srv:            srv,
+		config:         &Config{},
+		outStream:      outStream,
+		errStream:      errStream,
+		tmpContainers:  make(map[string]struct{}),
+		tmpImages:      make(map[string]struct{}),
+		verbose:        verbose,
+		utilizeCache:   utilizeCache,
+		rm:             rm,
+		dockerfileName: dockerfileName,
+		buildArgs:      buildArgs,
+		comment:        comment,
+		args:           make(map[string]string),
+		declaredArgs:   make(map[string]struct{}),
+		stageNames:     make(map[string]int),
+		sf:             sf,
+		outOld:         outOld,
 	}
 }
@@ -0,0 +1,44 @@
+package docker
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLintDockerfile(t *testing.T) {
+	for dockerfile, expected := range map[string][]APIDiagnostic{
+		"from busybox\nworkdir /app\nexpose 80 8080/udp $PORT\nadd . /app\n": {},
+		"arg version\nfrom busybox:$version\nrun true\n":                     {},
+		"run true\nfrom busybox\n": {
+			{Line: 1, Column: 1, Severity: "warning", Message: "The first instruction must be FROM, found RUN"},
+		},
+		"# Empty\n": {
+			{Line: 1, Column: 1, Severity: "warning", Message: "No FROM instruction"},
+		},
+		"from busybox\n  frobnicate all\nworkdir app\nworkdir $HOME\n": {
+			{Line: 2, Column: 3, Severity: "warning", Message: "Unknown instruction FROBNICATE"},
+			{Line: 3, Column: 1, Severity: "warning", Message: "WORKDIR app isn't an absolute path"},
+		},
+		"from busybox\nadd http://example.com/file.tar /tmp\nexpose 80 http 70000\n": {
+			{Line: 2, Column: 1, Severity: "warning", Message: "ADD downloads http://example.com/file.tar without a checksum to verify it"},
+			{Line: 3, Column: 1, Severity: "warning", Message: "Invalid port http: Invalid containerPort: http"},
+			{Line: 3, Column: 1, Severity: "warning", Message: "Invalid port 70000: Invalid containerPort: 70000"},
+		},
+		"from busybox\nrun\n": {
+			{Line: 2, Column: 4, Severity: "error", Message: "RUN requires at least one argument"},
+		},
+	} {
+		diagnostics, err := LintDockerfile(strings.NewReader(dockerfile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(diagnostics) != len(expected) {
+			t.Fatalf("Expected %v for %q, got %v", expected, dockerfile, diagnostics)
+		}
+		for i := range expected {
+			if diagnostics[i] != expected[i] {
+				t.Fatalf("Expected %v for %q, got %v", expected[i], dockerfile, diagnostics[i])
+			}
+		}
+	}
+}
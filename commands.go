@@ -23,6 +23,7 @@ import (
 	"os"
 	"os/signal"
 	"path"
+	"path/filepath"
 	"reflect"
 	"regexp"
 	"runtime"
@@ -106,6 +107,7 @@ func (cli *DockerCli) CmdHelp(args ...string) error {
 		{"run", "Run a command in a new container"},
 		{"save", "Save an image to a tar archive"},
 		{"search", "Search for an image in the docker index"},
+		{"squash", "Squash the layers of an image into a single layer"},
 		{"start", "Start a stopped container"},
 		{"stop", "Stop a running container"},
 		{"tag", "Tag an image into a repository"},
@@ -161,12 +163,38 @@ func MkBuildContext(dockerfile string, files [][2]string) (archive.Archive, erro
 	return buf, nil
 }
 
+// contextRelativePath returns the path of the file `name` relatively to the
+// build context, which must contain it.
+func contextRelativePath(context, name string) (string, error) {
+	absContext, err := filepath.Abs(context)
+	if err != nil {
+		return "", err
+	}
+	absName, err := filepath.Abs(name)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(absContext, absName)
+	if err != nil {
+		return "", err
+	}
+	if rel == "." || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("The Dockerfile (%s) must be within the build context (%s)", name, context)
+	}
+	return rel, nil
+}
+
 func (cli *DockerCli) CmdBuild(args ...string) error {
 	cmd := cli.Subcmd("build", "[OPTIONS] PATH | URL | -", "Build a new container image from the source code at PATH")
 	tag := cmd.String("t", "", "Repository name (and optionally a tag) to be applied to the resulting image in case of success")
 	suppressOutput := cmd.Bool("q", false, "Suppress verbose build output")
 	noCache := cmd.Bool("no-cache", false, "Do not use cache when building the image")
 	rm := cmd.Bool("rm", false, "Remove intermediate containers after a successful build")
+	dockerfileName := cmd.String("f", "", "Name of the Dockerfile (Default is 'PATH/Dockerfile')")
+	flBuildArgs := NewListOpts(ValidateEnv)
+	cmd.Var(&flBuildArgs, "build-arg", "Set a build argument declared by ARG, ex: -build-arg HTTP_PROXY=http://10.20.30.2:1234")
+	progress := cmd.String("progress", "text", "Output of the build: text, or json for one build event per line")
+	check := cmd.Bool("check", false, "Check the Dockerfile for problems without building it")
 	if err := cmd.Parse(args); err != nil {
 		return nil
 	}
@@ -174,6 +202,12 @@ func (cli *DockerCli) CmdBuild(args ...string) error {
 		cmd.Usage()
 		return nil
 	}
+	if *progress != "text" && *progress != "json" {
+		return fmt.Errorf("Invalid -progress %s: text or json expected", *progress)
+	}
+	if *check {
+		return cli.checkDockerfile(cmd.Arg(0), *dockerfileName)
+	}
 
 	var (
 		context  archive.Archive
@@ -182,6 +216,9 @@ func (cli *DockerCli) CmdBuild(args ...string) error {
 	)
 
 	if cmd.Arg(0) == "-" {
+		if *dockerfileName != "" {
+			return fmt.Errorf("The Dockerfile is read from stdin, -f can't be used with it")
+		}
 		// As a special case, 'docker build -' will build from an empty context with the
 		// contents of stdin as a Dockerfile
 		dockerfile, err := ioutil.ReadAll(cli.in)
@@ -195,11 +232,35 @@ func (cli *DockerCli) CmdBuild(args ...string) error {
 		if _, err := os.Stat(cmd.Arg(0)); err != nil {
 			return err
 		}
-		filename := path.Join(cmd.Arg(0), "Dockerfile")
-		if _, err = os.Stat(filename); os.IsNotExist(err) {
-			return fmt.Errorf("no Dockerfile found in %s", cmd.Arg(0))
+		dockerfile := "Dockerfile"
+		if *dockerfileName != "" {
+			// The Dockerfile is given relatively to the current directory,
+			// but the daemon looks it up in the context
+			if *dockerfileName, err = contextRelativePath(cmd.Arg(0), *dockerfileName); err != nil {
+				return err
+			}
+			dockerfile = *dockerfileName
+		}
+		if _, err = os.Stat(path.Join(cmd.Arg(0), dockerfile)); os.IsNotExist(err) {
+			return fmt.Errorf("no %s found in %s", dockerfile, cmd.Arg(0))
+		}
+		patterns, err := archive.ReadExcludes(cmd.Arg(0))
+		if err != nil {
+			return err
+		}
+		var excludes []string
+		if patterns != nil {
+			// The daemon needs the Dockerfile, and the .dockerignore to
+			// know what ADD can't use
+			excludes, err = archive.ExcludePatterns(cmd.Arg(0), cmd.Arg(0), patterns, []string{dockerfile, ".dockerignore"})
+			if err != nil {
+				return err
+			}
+		}
+		context, err = archive.TarFilter(cmd.Arg(0), &archive.TarOptions{Recursive: true, Compression: archive.Uncompressed, Excludes: excludes})
+		if err != nil {
+			return err
 		}
-		context, err = archive.Tar(cmd.Arg(0), archive.Uncompressed)
 	}
 	var body io.Reader
 	// Setup an upload progress bar
@@ -224,20 +285,91 @@ func (cli *DockerCli) CmdBuild(args ...string) error {
 	if *rm {
 		v.Set("rm", "1")
 	}
+	if *dockerfileName != "" {
+		v.Set("dockerfile", *dockerfileName)
+	}
+	if flBuildArgs.Len() > 0 {
+		buildArgs := make(map[string]string)
+		for _, arg := range flBuildArgs.GetAll() {
+			parts := strings.SplitN(arg, "=", 2)
+			buildArgs[parts[0]] = parts[1]
+		}
+		buf, err := json.Marshal(buildArgs)
+		if err != nil {
+			return err
+		}
+		v.Set("buildargs", string(buf))
+	}
 
 	headers := http.Header(make(map[string][]string))
 	if context != nil {
 		headers.Set("Content-Type", "application/tar")
 	}
-	// Temporary hack to fix displayJSON behavior
-	cli.isTerminal = false
-	err = cli.stream("POST", fmt.Sprintf("/build?%s", v.Encode()), body, cli.out, headers)
+	if *progress == "json" {
+		err = cli.streamDisplay("POST", fmt.Sprintf("/build?%s", v.Encode()), body, cli.out, headers, utils.DisplayJSONBuildEvents)
+	} else {
+		// Temporary hack to fix displayJSON behavior
+		cli.isTerminal = false
+		err = cli.stream("POST", fmt.Sprintf("/build?%s", v.Encode()), body, cli.out, headers)
+	}
 	if jerr, ok := err.(*utils.JSONError); ok {
 		return &utils.StatusError{Status: jerr.Message, StatusCode: jerr.Code}
 	}
 	return err
 }
 
+// checkDockerfile prints the problems of the Dockerfile of a local context,
+// or of stdin, found by the daemon. It fails if the Dockerfile can't be
+// parsed.
+func (cli *DockerCli) checkDockerfile(context, name string) error {
+	var dockerfile io.Reader
+	if context == "-" {
+		if name != "" {
+			return fmt.Errorf("The Dockerfile is read from stdin, -f can't be used with it")
+		}
+		dockerfile, name = cli.in, "Dockerfile"
+	} else if utils.IsURL(context) || utils.IsGIT(context) {
+		return fmt.Errorf("Only the Dockerfile of a local context can be checked")
+	} else {
+		if name == "" {
+			name = "Dockerfile"
+		} else {
+			var err error
+			if name, err = contextRelativePath(context, name); err != nil {
+				return err
+			}
+		}
+		f, err := os.Open(path.Join(context, name))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		dockerfile = f
+	}
+
+	failed := false
+	err := cli.streamDisplay("POST", "/build/validate", dockerfile, cli.out, nil, func(in io.Reader, out io.Writer) error {
+		var diagnostics []APIDiagnostic
+		if err := json.NewDecoder(in).Decode(&diagnostics); err != nil {
+			return err
+		}
+		for _, diagnostic := range diagnostics {
+			fmt.Fprintf(out, "%s line %d, column %d: %s: %s\n", name, diagnostic.Line, diagnostic.Column, diagnostic.Severity, diagnostic.Message)
+			if diagnostic.Severity == "error" {
+				failed = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if failed {
+		return fmt.Errorf("%s has errors", name)
+	}
+	return nil
+}
+
 // 'docker login': login / register a user to registry service.
 func (cli *DockerCli) CmdLogin(args ...string) error {
 	cmd := cli.Subcmd("login", "[OPTIONS] [SERVER]", "Register or Login to a docker registry server, if no server is specified \""+auth.IndexServerAddress()+"\" is the default.")
@@ -279,10 +411,7 @@ func (cli *DockerCli) CmdLogin(args ...string) error {
 	}
 
 	cli.LoadConfigFile()
-	authconfig, ok := cli.configFile.Configs[serverAddress]
-	if !ok {
-		authconfig = auth.AuthConfig{}
-	}
+	authconfig := cli.configFile.ResolveAuthConfig(serverAddress)
 
 	if username == "" {
 		promptDefault("Username", authconfig.Username)
@@ -325,7 +454,9 @@ func (cli *DockerCli) CmdLogin(args ...string) error {
 
 	body, statusCode, err := cli.call("POST", "/auth", cli.configFile.Configs[serverAddress])
 	if statusCode == 401 {
-		delete(cli.configFile.Configs, serverAddress)
+		if err := cli.configFile.Forget(serverAddress); err != nil {
+			fmt.Fprintf(cli.err, "%s\n", err)
+		}
 		auth.SaveConfig(cli.configFile)
 		return err
 	}
@@ -339,7 +470,9 @@ func (cli *DockerCli) CmdLogin(args ...string) error {
 		cli.configFile, _ = auth.LoadConfig(os.Getenv("HOME"))
 		return err
 	}
-	auth.SaveConfig(cli.configFile)
+	if err := auth.SaveConfig(cli.configFile); err != nil {
+		return err
+	}
 	if out2.Status != "" {
 		fmt.Fprintf(cli.out, "%s\n", out2.Status)
 	}
@@ -457,6 +590,13 @@ func (cli *DockerCli) CmdInfo(args ...string) error {
 		fmt.Fprintf(cli.out, "Kernel Version: %s\n", out.KernelVersion)
 	}
 
+	if len(out.RegistryMirrors) > 0 {
+		fmt.Fprintf(cli.out, "Registry Mirrors:\n")
+		for _, mirror := range out.RegistryMirrors {
+			fmt.Fprintf(cli.out, " %s: %s\n", mirror[0], mirror[1])
+		}
+	}
+
 	if len(out.IndexServerAddress) != 0 {
 		cli.LoadConfigFile()
 		u := cli.configFile.Configs[out.IndexServerAddress].Username
@@ -647,10 +787,8 @@ func (cli *DockerCli) CmdInspect(args ...string) error {
 	var tmpl *template.Template
 	if *tmplStr != "" {
 		var err error
-		if tmpl, err = template.New("").Parse(*tmplStr); err != nil {
-			fmt.Fprintf(cli.err, "Template parsing error: %v\n", err)
-			return &utils.StatusError{StatusCode: 64,
-				Status: "Template parsing error: " + err.Error()}
+		if tmpl, err = newFormatTemplate(*tmplStr); err != nil {
+			return cli.templateParseError(err)
 		}
 	}
 
@@ -823,6 +961,7 @@ func (cli *DockerCli) CmdHistory(args ...string) error {
 	cmd := cli.Subcmd("history", "[OPTIONS] IMAGE", "Show the history of an image")
 	quiet := cmd.Bool("q", false, "only show numeric IDs")
 	noTrunc := cmd.Bool("notrunc", false, "Don't truncate output")
+	format := cmd.String("format", "", "Format the output using the given go template (prefix it with 'table' to keep the headers)")
 
 	if err := cmd.Parse(args); err != nil {
 		return nil
@@ -832,6 +971,14 @@ func (cli *DockerCli) CmdHistory(args ...string) error {
 		return nil
 	}
 
+	var formatter *listFormatter
+	if *format != "" && !*quiet {
+		var err error
+		if formatter, err = newListFormatter(cli.out, *format, historyDefaultRow, historyHeaders); err != nil {
+			return cli.templateParseError(err)
+		}
+	}
+
 	body, _, err := cli.call("GET", "/images/"+cmd.Arg(0)+"/history", nil)
 	if err != nil {
 		return err
@@ -843,6 +990,27 @@ func (cli *DockerCli) CmdHistory(args ...string) error {
 		return err
 	}
 
+	if formatter != nil {
+		for _, out := range outs {
+			row := historyRow{
+				ID:           out.ID,
+				Tags:         strings.Join(out.Tags, ","),
+				CreatedAt:    time.Unix(out.Created, 0).Format(time.RFC3339),
+				CreatedSince: utils.HumanDuration(time.Now().UTC().Sub(time.Unix(out.Created, 0))) + " ago",
+				CreatedBy:    out.CreatedBy,
+				Size:         utils.HumanSize(out.Size),
+			}
+			if !*noTrunc {
+				row.ID = utils.TruncateID(row.ID)
+				row.CreatedBy = utils.Trunc(row.CreatedBy, 45)
+			}
+			if err := formatter.Write(row); err != nil {
+				return err
+			}
+		}
+		return formatter.Flush()
+	}
+
 	w := tabwriter.NewWriter(cli.out, 20, 1, 3, ' ', 0)
 	if !*quiet {
 		fmt.Fprintln(w, "IMAGE\tCREATED\tCREATED BY\tSIZE")
@@ -967,16 +1135,21 @@ func (cli *DockerCli) CmdImport(args ...string) error {
 }
 
 func (cli *DockerCli) CmdPush(args ...string) error {
-	cmd := cli.Subcmd("push", "NAME", "Push an image or a repository to the registry")
+	cmd := cli.Subcmd("push", "NAME[:TAG]", "Push an image or a repository to the registry")
 	if err := cmd.Parse(args); err != nil {
 		return nil
 	}
-	name := cmd.Arg(0)
+	name, tag := utils.ParseRepositoryTag(cmd.Arg(0))
 
 	if name == "" {
 		cmd.Usage()
 		return nil
 	}
+	if registry.IsDirEndpoint(name) {
+		// Push "dir:///mnt/images/app" as "dir:/mnt/images/app", as a pull
+		// does: the name is part of the remote api url
+		name = path.Clean(name)
+	}
 
 	cli.LoadConfigFile()
 
@@ -1000,6 +1173,7 @@ func (cli *DockerCli) CmdPush(args ...string) error {
 	}
 
 	v := url.Values{}
+	v.Set("tag", tag)
 	push := func(authConfig auth.AuthConfig) error {
 		buf, err := json.Marshal(authConfig)
 		if err != nil {
@@ -1040,7 +1214,7 @@ func (cli *DockerCli) CmdPull(args ...string) error {
 		return nil
 	}
 
-	remote, parsedTag := utils.ParseRepositoryTag(cmd.Arg(0))
+	remote, parsedTag := utils.ParseRepositoryRef(cmd.Arg(0))
 	if *tag == "" {
 		*tag = parsedTag
 	}
@@ -1093,8 +1267,13 @@ func (cli *DockerCli) CmdImages(args ...string) error {
 	quiet := cmd.Bool("q", false, "only show numeric IDs")
 	all := cmd.Bool("a", false, "show all images (by default filter out the intermediate images used to build)")
 	noTrunc := cmd.Bool("notrunc", false, "Don't truncate output")
+	flDigests := cmd.Bool("digests", false, "show digests")
 	flViz := cmd.Bool("viz", false, "output graph in graphviz format")
 	flTree := cmd.Bool("tree", false, "output graph in tree format")
+	format := cmd.String("format", "", "Format the output using the given go template (prefix it with 'table' to keep the headers)")
+
+	var flLabels ListOpts
+	cmd.Var(&flLabels, "label", "Only show images with the given label (key or key=value)")
 
 	if err := cmd.Parse(args); err != nil {
 		return nil
@@ -1104,6 +1283,14 @@ func (cli *DockerCli) CmdImages(args ...string) error {
 		return nil
 	}
 
+	var formatter *listFormatter
+	if *format != "" && !*quiet {
+		var err error
+		if formatter, err = newListFormatter(cli.out, *format, imagesDefaultRow, imagesHeaders); err != nil {
+			return cli.templateParseError(err)
+		}
+	}
+
 	if *flViz {
 		body, _, err := cli.call("GET", "/images/json?all=1", nil)
 		if err != nil {
@@ -1186,6 +1373,9 @@ func (cli *DockerCli) CmdImages(args ...string) error {
 		if *all {
 			v.Set("all", "1")
 		}
+		for _, label := range flLabels.GetAll() {
+			v.Add("label", label)
+		}
 
 		body, _, err := cli.call("GET", "/images/json?"+v.Encode(), nil)
 		if err != nil {
@@ -1198,9 +1388,41 @@ func (cli *DockerCli) CmdImages(args ...string) error {
 			return err
 		}
 
+		if formatter != nil {
+			for _, out := range outs {
+				for _, repotag := range out.RepoTags {
+					repo, tag := utils.ParseRepositoryTag(repotag)
+					row := imagesRow{
+						Repository:   repo,
+						Tag:          tag,
+						Digest:       imageDigest(out, repo),
+						ID:           out.ID,
+						ParentID:     out.ParentId,
+						CreatedAt:    time.Unix(out.Created, 0).Format(time.RFC3339),
+						CreatedSince: utils.HumanDuration(time.Now().UTC().Sub(time.Unix(out.Created, 0))) + " ago",
+						Size:         utils.HumanSize(out.Size),
+						VirtualSize:  utils.HumanSize(out.VirtualSize),
+						Labels:       formatLabels(out.Labels),
+					}
+					if !*noTrunc {
+						row.ID = utils.TruncateID(row.ID)
+						row.ParentID = utils.TruncateID(row.ParentID)
+					}
+					if err := formatter.Write(row); err != nil {
+						return err
+					}
+				}
+			}
+			return formatter.Flush()
+		}
+
 		w := tabwriter.NewWriter(cli.out, 20, 1, 3, ' ', 0)
 		if !*quiet {
-			fmt.Fprintln(w, "REPOSITORY\tTAG\tIMAGE ID\tCREATED\tSIZE")
+			if *flDigests {
+				fmt.Fprintln(w, "REPOSITORY\tTAG\tDIGEST\tIMAGE ID\tCREATED\tSIZE")
+			} else {
+				fmt.Fprintln(w, "REPOSITORY\tTAG\tIMAGE ID\tCREATED\tSIZE")
+			}
 		}
 
 		for _, out := range outs {
@@ -1213,7 +1435,12 @@ func (cli *DockerCli) CmdImages(args ...string) error {
 				}
 
 				if !*quiet {
-					fmt.Fprintf(w, "%s\t%s\t%s\t%s ago\t", repo, tag, out.ID, utils.HumanDuration(time.Now().UTC().Sub(time.Unix(out.Created, 0))))
+					if *flDigests {
+						fmt.Fprintf(w, "%s\t%s\t%s\t", repo, tag, imageDigest(out, repo))
+					} else {
+						fmt.Fprintf(w, "%s\t%s\t", repo, tag)
+					}
+					fmt.Fprintf(w, "%s\t%s ago\t", out.ID, utils.HumanDuration(time.Now().UTC().Sub(time.Unix(out.Created, 0))))
 					if out.VirtualSize > 0 {
 						fmt.Fprintf(w, "%s (virtual %s)\n", utils.HumanSize(out.Size), utils.HumanSize(out.VirtualSize))
 					} else {
@@ -1232,6 +1459,16 @@ func (cli *DockerCli) CmdImages(args ...string) error {
 	return nil
 }
 
+// imageDigest returns the digest referring to image in the repository repo.
+func imageDigest(image APIImages, repo string) string {
+	for _, repoDigest := range image.RepoDigests {
+		if r, digest := utils.ParseRepositoryRef(repoDigest); r == repo {
+			return digest
+		}
+	}
+	return "<none>"
+}
+
 func WalkTree(cli *DockerCli, noTrunc *bool, images []APIImages, byParent map[string][]APIImages, prefix string) {
 	if len(images) > 1 {
 		length := len(images)
@@ -1297,10 +1534,26 @@ func (cli *DockerCli) CmdPs(args ...string) error {
 	since := cmd.String("sinceId", "", "Show only containers created since Id, include non-running ones.")
 	before := cmd.String("beforeId", "", "Show only container created before Id, include non-running ones.")
 	last := cmd.Int("n", -1, "Show n last created containers, include non-running ones.")
+	format := cmd.String("format", "", "Format the output using the given go template (prefix it with 'table' to keep the headers)")
+
+	var flLabels ListOpts
+	cmd.Var(&flLabels, "label", "Only show containers with the given label (key or key=value)")
 
 	if err := cmd.Parse(args); err != nil {
 		return nil
 	}
+
+	var formatter *listFormatter
+	if *format != "" && !*quiet {
+		defaultRow := psDefaultRow
+		if *size {
+			defaultRow += "\t{{.Size}}"
+		}
+		var err error
+		if formatter, err = newListFormatter(cli.out, *format, defaultRow, psHeaders); err != nil {
+			return cli.templateParseError(err)
+		}
+	}
 	v := url.Values{}
 	if *last == -1 && *nLatest {
 		*last = 1
@@ -1320,6 +1573,9 @@ func (cli *DockerCli) CmdPs(args ...string) error {
 	if *size {
 		v.Set("size", "1")
 	}
+	for _, label := range flLabels.GetAll() {
+		v.Add("label", label)
+	}
 
 	body, _, err := cli.call("GET", "/containers/json?"+v.Encode(), nil)
 	if err != nil {
@@ -1331,6 +1587,40 @@ func (cli *DockerCli) CmdPs(args ...string) error {
 	if err != nil {
 		return err
 	}
+
+	if formatter != nil {
+		for _, out := range outs {
+			// Remove the leading / from the names
+			for i := 0; i < len(out.Names); i++ {
+				out.Names[i] = out.Names[i][1:]
+			}
+			row := psRow{
+				ID:           out.ID,
+				Image:        out.Image,
+				Command:      out.Command,
+				CreatedAt:    time.Unix(out.Created, 0).Format(time.RFC3339),
+				CreatedSince: utils.HumanDuration(time.Now().UTC().Sub(time.Unix(out.Created, 0))) + " ago",
+				Status:       out.Status,
+				Ports:        displayablePorts(out.Ports),
+				Names:        strings.Join(out.Names, ","),
+				Labels:       formatLabels(out.Labels),
+			}
+			if !*noTrunc {
+				row.ID = utils.TruncateID(row.ID)
+				row.Command = utils.Trunc(row.Command, 20)
+			}
+			if out.SizeRootFs > 0 {
+				row.Size = fmt.Sprintf("%s (virtual %s)", utils.HumanSize(out.SizeRw), utils.HumanSize(out.SizeRootFs))
+			} else if *size {
+				row.Size = utils.HumanSize(out.SizeRw)
+			}
+			if err := formatter.Write(row); err != nil {
+				return err
+			}
+		}
+		return formatter.Flush()
+	}
+
 	w := tabwriter.NewWriter(cli.out, 20, 1, 3, ' ', 0)
 	if !*quiet {
 		fmt.Fprint(w, "CONTAINER ID\tIMAGE\tCOMMAND\tCREATED\tSTATUS\tPORTS\tNAMES")
@@ -1479,11 +1769,15 @@ func (cli *DockerCli) CmdExport(args ...string) error {
 }
 
 func (cli *DockerCli) CmdDiff(args ...string) error {
-	cmd := cli.Subcmd("diff", "CONTAINER", "Inspect changes on a container's filesystem")
+	cmd := cli.Subcmd("diff", "CONTAINER [CONTAINER] | -image IMAGE IMAGE", "Inspect changes on a container's filesystem, or between two containers or two images")
+	flImage := cmd.Bool("image", false, "Compare two images")
 	if err := cmd.Parse(args); err != nil {
 		return nil
 	}
-	if cmd.NArg() != 1 {
+	if cmd.NArg() == 2 {
+		return cli.diffBetween(*flImage, cmd.Arg(0), cmd.Arg(1))
+	}
+	if cmd.NArg() != 1 || *flImage {
 		cmd.Usage()
 		return nil
 	}
@@ -1504,6 +1798,44 @@ func (cli *DockerCli) CmdDiff(args ...string) error {
 	return nil
 }
 
+// diffBetween prints the changes needed to go from the filesystem of the
+// container (or image) `from` to the one of `to`, with their size.
+func (cli *DockerCli) diffBetween(images bool, from, to string) error {
+	v := url.Values{}
+	v.Set("from", from)
+	v.Set("to", to)
+	path := "/containers/diff?"
+	if images {
+		path = "/images/diff?"
+	}
+	body, _, err := cli.call("GET", path+v.Encode(), nil)
+	if err != nil {
+		return err
+	}
+
+	changes := []APIChange{}
+	if err := json.Unmarshal(body, &changes); err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(cli.out, 20, 1, 3, ' ', 0)
+	var total int64
+	for _, out := range changes {
+		change := archive.Change{Path: out.Path, Kind: out.Kind}
+		fmt.Fprintf(w, "%s\t%s\n", change.String(), formatSizeDelta(out.SizeDelta))
+		total += out.SizeDelta
+	}
+	fmt.Fprintf(w, "Total\t%s\n", formatSizeDelta(total))
+	w.Flush()
+	return nil
+}
+
+func formatSizeDelta(delta int64) string {
+	if delta < 0 {
+		return "-" + utils.HumanSize(-delta)
+	}
+	return "+" + utils.HumanSize(delta)
+}
+
 func (cli *DockerCli) CmdLogs(args ...string) error {
 	cmd := cli.Subcmd("logs", "CONTAINER", "Fetch the logs of a container")
 	follow := cmd.Bool("f", false, "Follow log output")
@@ -1658,6 +1990,35 @@ func (cli *DockerCli) CmdSearch(args ...string) error {
 // Ports type - Used to parse multiple -p flags
 type ports []int
 
+func (cli *DockerCli) CmdSquash(args ...string) error {
+	cmd := cli.Subcmd("squash", "[OPTIONS] IMAGE [REPOSITORY[:TAG]]", "Squash the layers of an image into a single layer")
+	flFrom := cmd.String("from", "", "Only squash the layers above this ancestor image (by default all the layers are squashed)")
+	if err := cmd.Parse(args); err != nil {
+		return nil
+	}
+	if cmd.NArg() != 1 && cmd.NArg() != 2 {
+		cmd.Usage()
+		return nil
+	}
+
+	repository, tag := utils.ParseRepositoryTag(cmd.Arg(1))
+	v := url.Values{}
+	v.Set("from", *flFrom)
+	v.Set("repo", repository)
+	v.Set("tag", tag)
+	body, _, err := cli.call("POST", "/images/"+cmd.Arg(0)+"/squash?"+v.Encode(), nil)
+	if err != nil {
+		return err
+	}
+
+	apiID := &APIID{}
+	if err := json.Unmarshal(body, apiID); err != nil {
+		return err
+	}
+	fmt.Fprintf(cli.out, "%s\n", apiID.ID)
+	return nil
+}
+
 func (cli *DockerCli) CmdTag(args ...string) error {
 	cmd := cli.Subcmd("tag", "[OPTIONS] IMAGE REPOSITORY[:TAG]", "Tag an image into a repository")
 	force := cmd.Bool("f", false, "Force")
@@ -1707,6 +2068,7 @@ func parseRun(cmd *flag.FlagSet, args []string, capabilities *Capabilities) (*Co
 		flVolumes = NewListOpts(ValidatePath)
 		flLinks   = NewListOpts(ValidateLink)
 		flEnv     = NewListOpts(ValidateEnv)
+		flLabels  = NewListOpts(ValidateLabel)
 
 		flPublish     ListOpts
 		flExpose      ListOpts
@@ -1738,6 +2100,7 @@ func parseRun(cmd *flag.FlagSet, args []string, capabilities *Capabilities) (*Co
 	cmd.Var(&flVolumes, "v", "Bind mount a volume (e.g. from the host: -v /host:/container, from docker: -v /container)")
 	cmd.Var(&flLinks, "link", "Add link to another container (name:alias)")
 	cmd.Var(&flEnv, "e", "Set environment variables")
+	cmd.Var(&flLabels, "l", "Set meta data on the container (e.g. -l owner=ops)")
 
 	cmd.Var(&flPublish, "p", fmt.Sprintf("Publish a container's port to the host (format: %s) (use 'docker port' to see the actual mapping)", PortSpecTemplateFormat))
 	cmd.Var(&flExpose, "expose", "Expose a port from the container without publishing it to your host")
@@ -1846,6 +2209,15 @@ func parseRun(cmd *flag.FlagSet, args []string, capabilities *Capabilities) (*Co
 		}
 	}
 
+	var labels map[string]string
+	if flLabels.Len() > 0 {
+		labels = make(map[string]string, flLabels.Len())
+		for _, l := range flLabels.GetAll() {
+			key, value := parseLabel(l)
+			labels[key] = value
+		}
+	}
+
 	config := &Config{
 		Hostname:        hostname,
 		Domainname:      domainname,
@@ -1868,6 +2240,7 @@ func parseRun(cmd *flag.FlagSet, args []string, capabilities *Capabilities) (*Co
 		VolumesFrom:     strings.Join(flVolumesFrom.GetAll(), ","),
 		Entrypoint:      entrypoint,
 		WorkingDir:      *flWorkingDir,
+		Labels:          labels,
 	}
 
 	hostConfig := &HostConfig{
@@ -1936,7 +2309,7 @@ func (cli *DockerCli) CmdRun(args ...string) error {
 	body, statusCode, err := cli.call("POST", "/containers/create?"+containerValues.Encode(), config)
 	//if image not found try to pull it
 	if statusCode == 404 {
-		_, tag := utils.ParseRepositoryTag(config.Image)
+		_, tag := utils.ParseRepositoryRef(config.Image)
 		if tag == "" {
 			tag = DEFAULTTAG
 		}
@@ -1944,7 +2317,7 @@ func (cli *DockerCli) CmdRun(args ...string) error {
 		fmt.Fprintf(cli.err, "Unable to find image '%s' (tag: %s) locally\n", config.Image, tag)
 
 		v := url.Values{}
-		repos, tag := utils.ParseRepositoryTag(config.Image)
+		repos, tag := utils.ParseRepositoryRef(config.Image)
 		v.Set("fromImage", repos)
 		v.Set("tag", tag)
 
@@ -2158,18 +2531,29 @@ func (cli *DockerCli) CmdCp(args ...string) error {
 }
 
 func (cli *DockerCli) CmdSave(args ...string) error {
-	cmd := cli.Subcmd("save", "IMAGE", "Save an image to a tar archive (streamed to stdout)")
+	cmd := cli.Subcmd("save", "IMAGE [IMAGE...]", "Save one or more images, repositories or repository:tag to a tar archive (streamed to stdout)")
 	if err := cmd.Parse(args); err != nil {
 		return err
 	}
 
-	if cmd.NArg() != 1 {
+	if cmd.NArg() < 1 {
 		cmd.Usage()
 		return nil
 	}
 
-	image := cmd.Arg(0)
-	if err := cli.stream("GET", "/images/"+image+"/get", nil, cli.out, nil); err != nil {
+	if cmd.NArg() == 1 {
+		image := cmd.Arg(0)
+		if err := cli.stream("GET", "/images/"+image+"/get", nil, cli.out, nil); err != nil {
+			return err
+		}
+		return nil
+	}
+
+	v := url.Values{}
+	for _, name := range cmd.Args() {
+		v.Add("names", name)
+	}
+	if err := cli.stream("GET", "/images/get?"+v.Encode(), nil, cli.out, nil); err != nil {
 		return err
 	}
 	return nil
@@ -2177,6 +2561,7 @@ func (cli *DockerCli) CmdSave(args ...string) error {
 
 func (cli *DockerCli) CmdLoad(args ...string) error {
 	cmd := cli.Subcmd("load", "SOURCE", "Load an image from a tar archive")
+	force := cmd.Bool("f", false, "Replace the tags which are already set to another image")
 	if err := cmd.Parse(args); err != nil {
 		return err
 	}
@@ -2186,7 +2571,11 @@ func (cli *DockerCli) CmdLoad(args ...string) error {
 		return nil
 	}
 
-	if err := cli.stream("POST", "/images/load", cli.in, cli.out, nil); err != nil {
+	v := url.Values{}
+	if *force {
+		v.Set("force", "1")
+	}
+	if err := cli.stream("POST", "/images/load?"+v.Encode(), cli.in, cli.out, nil); err != nil {
 		return err
 	}
 	return nil
@@ -2249,6 +2638,13 @@ func (cli *DockerCli) call(method, path string, data interface{}) ([]byte, int,
 }
 
 func (cli *DockerCli) stream(method, path string, in io.Reader, out io.Writer, headers map[string][]string) error {
+	return cli.streamDisplay(method, path, in, out, headers, func(in io.Reader, out io.Writer) error {
+		return utils.DisplayJSONMessagesStream(in, out, cli.terminalFd, cli.isTerminal)
+	})
+}
+
+// streamDisplay is stream, displaying a json response with display.
+func (cli *DockerCli) streamDisplay(method, path string, in io.Reader, out io.Writer, headers map[string][]string, display func(io.Reader, io.Writer) error) error {
 	if (method == "POST" || method == "PUT") && in == nil {
 		in = bytes.NewReader([]byte{})
 	}
@@ -2303,7 +2699,7 @@ func (cli *DockerCli) stream(method, path string, in io.Reader, out io.Writer, h
 	}
 
 	if matchesContentType(resp.Header.Get("Content-Type"), "application/json") {
-		return utils.DisplayJSONMessagesStream(resp.Body, out, cli.terminalFd, cli.isTerminal)
+		return display(resp.Body, out)
 	}
 	if _, err := io.Copy(out, resp.Body); err != nil {
 		return err
@@ -155,3 +155,49 @@ func TestParseRunVolumes(t *testing.T) {
 		t.Fatalf("Error parsing volume flags, `-v /tmp:/tmp:/tmp:/tmp` should fail but didn't")
 	}
 }
+
+func TestParseRunLabels(t *testing.T) {
+	if config, _ := mustParse(t, "-l owner=ops -l team=core -l canary"); len(config.Labels) != 3 {
+		t.Fatalf("Error parsing label flags, 3 labels expected. Received %v", config.Labels)
+	} else if config.Labels["owner"] != "ops" || config.Labels["team"] != "core" {
+		t.Fatalf("Error parsing label flags, `-l owner=ops -l team=core` expected. Received %v", config.Labels)
+	} else if value, exists := config.Labels["canary"]; !exists || value != "" {
+		t.Fatalf("Error parsing label flags, `-l canary` should set an empty label. Received %v", config.Labels)
+	}
+
+	if config, _ := mustParse(t, ""); config.Labels != nil {
+		t.Fatalf("Error parsing label flags, without label, no label should be set. Received %v", config.Labels)
+	}
+
+	if _, _, err := parse(t, "-l =ops"); err == nil {
+		t.Fatalf("Error parsing label flags, `-l =ops` should fail but didn't")
+	}
+}
+
+func TestContextRelativePath(t *testing.T) {
+	for _, c := range [][3]string{
+		{".", "Dockerfile.api", "Dockerfile.api"},
+		{".", "./docker/api/Dockerfile", "docker/api/Dockerfile"},
+		{"docker", "docker/api/Dockerfile", "api/Dockerfile"},
+		{"/src/project", "/src/project/images/../Dockerfile", "Dockerfile"},
+	} {
+		rel, err := contextRelativePath(c[0], c[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rel != c[2] {
+			t.Fatalf("Expected %s relatively to %s to be %s, got %s", c[1], c[0], c[2], rel)
+		}
+	}
+
+	for _, c := range [][2]string{
+		{"docker", "Dockerfile"},
+		{".", "../Dockerfile"},
+		{"/src/project", "/src/project"},
+		{"/src/project", "/src/project2/Dockerfile"},
+	} {
+		if _, err := contextRelativePath(c[0], c[1]); err == nil {
+			t.Fatalf("Expected %s to be refused outside of %s", c[1], c[0])
+		}
+	}
+}
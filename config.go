@@ -5,6 +5,18 @@ import (
 	"net"
 )
 
+// DefaultMaxConcurrentDownloads is the number of layers fetched at the
+// same time by each pull, unless the daemon is told otherwise.
+const DefaultMaxConcurrentDownloads = 3
+
+// DefaultMaxConcurrentUploads is the number of layers uploaded at the same
+// time by each push, unless the daemon is told otherwise.
+const DefaultMaxConcurrentUploads = 3
+
+// DefaultRegistryCertsDir holds a directory per registry host, with the CA
+// bundles to trust and the client certificate to present to it.
+const DefaultRegistryCertsDir = "/etc/docker/certs.d"
+
 // FIXME: separate runtime configuration from http api configuration
 type DaemonConfig struct {
 	Pidfile                     string
@@ -17,6 +29,11 @@ type DaemonConfig struct {
 	DefaultIp                   net.IP
 	InterContainerCommunication bool
 	GraphDriver                 string
+	MaxConcurrentDownloads      int
+	MaxConcurrentUploads        int
+	Mirrors                     []string
+	InsecureRegistries          []string
+	RegistryCertsDir            string
 }
 
 // ConfigFromJob creates and returns a new DaemonConfig object
@@ -39,5 +56,25 @@ func ConfigFromJob(job *engine.Job) *DaemonConfig {
 	config.DefaultIp = net.ParseIP(job.Getenv("DefaultIp"))
 	config.InterContainerCommunication = job.GetenvBool("InterContainerCommunication")
 	config.GraphDriver = job.Getenv("GraphDriver")
+	if n := job.GetenvInt("MaxConcurrentDownloads"); n > 0 {
+		config.MaxConcurrentDownloads = int(n)
+	} else {
+		config.MaxConcurrentDownloads = DefaultMaxConcurrentDownloads
+	}
+	if n := job.GetenvInt("MaxConcurrentUploads"); n > 0 {
+		config.MaxConcurrentUploads = int(n)
+	} else {
+		config.MaxConcurrentUploads = DefaultMaxConcurrentUploads
+	}
+	config.Mirrors = job.GetenvList("Mirrors")
+	// A registry on the loopback interface is trusted when it is reached as
+	// localhost or by address. These entries are matched against the host
+	// as given, unlike a CIDR, so no DNS answer can make another host trusted.
+	config.InsecureRegistries = append(job.GetenvList("InsecureRegistries"), "localhost", "127.0.0.1", "::1")
+	if dir := job.Getenv("RegistryCertsDir"); dir != "" {
+		config.RegistryCertsDir = dir
+	} else {
+		config.RegistryCertsDir = DefaultRegistryCertsDir
+	}
 	return &config
 }
@@ -56,6 +56,16 @@ func TestCompareConfig(t *testing.T) {
 	if CompareConfig(&config1, &config5) {
 		t.Fatalf("CompareConfig should return false, Volumes are different")
 	}
+	config6 := config1
+	config6.Labels = map[string]string{"owner": "ops"}
+	config7 := config1
+	config7.Labels = map[string]string{"owner": "dev"}
+	if CompareConfig(&config1, &config6) {
+		t.Fatalf("CompareConfig should return false, Labels are different")
+	}
+	if CompareConfig(&config6, &config7) {
+		t.Fatalf("CompareConfig should return false, Label values are different")
+	}
 	if !CompareConfig(&config1, &config1) {
 		t.Fatalf("CompareConfig should return true")
 	}
@@ -71,6 +81,7 @@ func TestMergeConfig(t *testing.T) {
 		Env:         []string{"VAR1=1", "VAR2=2"},
 		VolumesFrom: "1111",
 		Volumes:     volumesImage,
+		Labels:      map[string]string{"owner": "ops", "team": "core"},
 	}
 
 	volumesUser := make(map[string]struct{})
@@ -80,6 +91,7 @@ func TestMergeConfig(t *testing.T) {
 		PortSpecs: []string{"3333:2222", "3333:3333"},
 		Env:       []string{"VAR2=3", "VAR3=3"},
 		Volumes:   volumesUser,
+		Labels:    map[string]string{"owner": "dev"},
 	}
 
 	if err := MergeConfig(configUser, configImage); err != nil {
@@ -125,6 +137,10 @@ func TestMergeConfig(t *testing.T) {
 		t.Fatalf("Expected VolumesFrom to be 1111, found %s", configUser.VolumesFrom)
 	}
 
+	if len(configUser.Labels) != 2 || configUser.Labels["owner"] != "dev" || configUser.Labels["team"] != "core" {
+		t.Fatalf("Expected labels owner=dev and team=core, found %v", configUser.Labels)
+	}
+
 	ports, _, err := parsePortSpecs([]string{"0000"})
 	if err != nil {
 		t.Error(err)
@@ -147,3 +163,17 @@ func TestMergeConfig(t *testing.T) {
 	}
 
 }
+
+func TestMatchLabels(t *testing.T) {
+	labels := map[string]string{"owner": "ops", "canary": ""}
+	for _, filters := range [][]string{nil, {"owner"}, {"owner=ops"}, {"canary"}, {"canary="}, {"owner=ops", "canary"}} {
+		if !matchLabels(labels, filters) {
+			t.Fatalf("Expected %v to match %v", labels, filters)
+		}
+	}
+	for _, filters := range [][]string{{"team"}, {"owner=dev"}, {"owner="}, {"owner", "team"}} {
+		if matchLabels(labels, filters) {
+			t.Fatalf("Expected %v not to match %v", labels, filters)
+		}
+	}
+}
@@ -101,6 +101,8 @@ type Config struct {
 	WorkingDir      string
 	Entrypoint      []string
 	NetworkDisabled bool
+	Labels          map[string]string
+	OnBuild         []string // Instructions run when the image is the base of a build
 }
 
 type HostConfig struct {
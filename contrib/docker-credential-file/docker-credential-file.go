@@ -0,0 +1,94 @@
+// docker-credential-file is a credentials helper storing the credentials of
+// the registries in a json file, $DOCKER_CREDENTIAL_FILE or
+// ~/.docker-credentials by default. The credentials are not encrypted: it is
+// meant for testing the helpers support, and as an example.
+//
+// To use it, put it in the PATH and set "credsHelper" to "file" in the
+// entry of a registry in ~/.dockercfg.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+type credentials struct {
+	ServerURL string `json:",omitempty"`
+	Username  string
+	Secret    string
+}
+
+func storePath() string {
+	if p := os.Getenv("DOCKER_CREDENTIAL_FILE"); p != "" {
+		return p
+	}
+	return path.Join(os.Getenv("HOME"), ".docker-credentials")
+}
+
+func load() (map[string]credentials, error) {
+	store := make(map[string]credentials)
+	b, err := ioutil.ReadFile(storePath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return store, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &store); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func save(store map[string]credentials) error {
+	b, err := json.Marshal(store)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(storePath(), b, 0600)
+}
+
+func run(action string) error {
+	input, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return err
+	}
+	store, err := load()
+	if err != nil {
+		return err
+	}
+	switch action {
+	case "store":
+		var creds credentials
+		if err := json.Unmarshal(input, &creds); err != nil {
+			return err
+		}
+		store[creds.ServerURL] = credentials{Username: creds.Username, Secret: creds.Secret}
+		return save(store)
+	case "get":
+		creds, exists := store[strings.TrimSpace(string(input))]
+		if !exists {
+			return fmt.Errorf("credentials not found")
+		}
+		return json.NewEncoder(os.Stdout).Encode(creds)
+	case "erase":
+		delete(store, strings.TrimSpace(string(input)))
+		return save(store)
+	}
+	return fmt.Errorf("Unknown action: %s", action)
+}
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintf(os.Stderr, "Usage: %s store|get|erase\n", os.Args[0])
+		os.Exit(1)
+	}
+	if err := run(os.Args[1]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
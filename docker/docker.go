@@ -9,6 +9,7 @@ import (
 	"github.com/dotcloud/docker/utils"
 	"log"
 	"os"
+	"path"
 	"strings"
 )
 
@@ -39,8 +40,16 @@ func main() {
 		flInterContainerComm = flag.Bool("icc", true, "Enable inter-container communication")
 		flGraphDriver        = flag.String("s", "", "Force the docker runtime to use a specific storage driver")
 		flHosts              = docker.NewListOpts(docker.ValidateHost)
+		flMaxDownloads       = flag.Int("max-concurrent-downloads", docker.DefaultMaxConcurrentDownloads, "Maximum number of layers downloaded at the same time by each pull")
+		flMaxUploads         = flag.Int("max-concurrent-uploads", docker.DefaultMaxConcurrentUploads, "Maximum number of layers uploaded at the same time by each push")
+		flMirrors            = docker.NewListOpts(docker.ValidateMirror)
+		flInsecureRegistries = docker.NewListOpts(docker.ValidateInsecureRegistry)
+		flRegistryCerts      = flag.String("registry-certs", docker.DefaultRegistryCertsDir, "Directory with the CA (*.crt) and client certificates (*.cert, *.key) of each registry, in <host:port> subdirectories")
+		flRegistryServe      = flag.String("registry-serve", "", "Serve a registry on the given address (ex: 0.0.0.0:5000), with the images stored in <-g>/registry")
 	)
 	flag.Var(&flDns, "dns", "Force docker to use specific DNS servers")
+	flag.Var(&flMirrors, "registry-mirror", "Preferred registry mirror for pulls from the official index")
+	flag.Var(&flInsecureRegistries, "insecure-registry", "Registry (host[:port] or CIDR) which may be reached over http or with an unverified certificate")
 	flag.Var(&flHosts, "H", "Multiple tcp://host:port or unix://path/to/socket to bind in daemon mode, single connection otherwise")
 
 	flag.Parse()
@@ -59,6 +68,22 @@ func main() {
 	}
 	docker.GITCOMMIT = GITCOMMIT
 	docker.VERSION = VERSION
+	if *flRegistryServe != "" {
+		if flag.NArg() != 0 {
+			flag.Usage()
+			return
+		}
+		eng, err := engine.New(*flRoot)
+		if err != nil {
+			log.Fatal(err)
+		}
+		job := eng.Job("serveregistry", *flRegistryServe)
+		job.Setenv("Root", path.Join(*flRoot, "registry"))
+		if err := job.Run(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 	if *flDaemon {
 		if flag.NArg() != 0 {
 			flag.Usage()
@@ -80,6 +105,11 @@ func main() {
 		job.Setenv("DefaultIp", *flDefaultIp)
 		job.SetenvBool("InterContainerCommunication", *flInterContainerComm)
 		job.Setenv("GraphDriver", *flGraphDriver)
+		job.SetenvInt("MaxConcurrentDownloads", int64(*flMaxDownloads))
+		job.SetenvInt("MaxConcurrentUploads", int64(*flMaxUploads))
+		job.SetenvList("Mirrors", flMirrors.GetAll())
+		job.SetenvList("InsecureRegistries", flInsecureRegistries.GetAll())
+		job.Setenv("RegistryCertsDir", *flRegistryCerts)
 		if err := job.Run(); err != nil {
 			log.Fatal(err)
 		}
@@ -0,0 +1,102 @@
+package dockerfile
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+// Node is an instruction of a Dockerfile, with the lines it spans once its
+// continuation lines are joined.
+type Node struct {
+	Instruction string   // upper case, ex: RUN
+	Value       string   // the arguments as written
+	Args        []string // the elements of the JSON array, or Value alone
+	JSON        bool     // the arguments are a JSON array of strings
+	StartLine   int
+	EndLine     int
+	Column      int // column of the instruction on StartLine
+}
+
+func (n *Node) String() string {
+	return n.Instruction + " " + n.Value
+}
+
+// Error is a syntax error at a position of a Dockerfile. Lines and columns
+// start at 1.
+type Error struct {
+	File   string // the name of the Dockerfile, if not the default one
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e *Error) Error() string {
+	file := e.File
+	if file == "" {
+		file = "Dockerfile"
+	}
+	return fmt.Sprintf("%s line %d, column %d: %s", file, e.Line, e.Column, e.Msg)
+}
+
+var validInstruction = regexp.MustCompile(`^[A-Za-z]+$`)
+
+// Parse reads the instructions of a Dockerfile. Empty lines and comments
+// are skipped, and a line ending with a backslash continues on the next
+// one. The instructions themselves are not checked: Parse only returns an
+// error if the text isn't made of instructions with arguments.
+func Parse(r io.Reader) ([]*Node, error) {
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		nodes []*Node
+		lines = strings.Split(string(content), "\n")
+	)
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], " \t\r")
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed == "" || trimmed[0] == '#' {
+			continue
+		}
+		node := &Node{
+			StartLine: i + 1,
+			EndLine:   i + 1,
+			Column:    len(line) - len(trimmed) + 1,
+		}
+		for strings.HasSuffix(trimmed, "\\") && i+1 < len(lines) {
+			i++
+			trimmed = strings.TrimRight(strings.TrimSuffix(trimmed, "\\"), " \t") + strings.TrimRight(lines[i], " \t\r")
+			node.EndLine = i + 1
+		}
+		trimmed = strings.TrimSuffix(trimmed, "\\")
+
+		instruction := trimmed
+		if n := strings.IndexAny(trimmed, " \t"); n >= 0 {
+			instruction = trimmed[:n]
+			node.Value = strings.Trim(trimmed[n:], " \t")
+		}
+		if !validInstruction.MatchString(instruction) {
+			return nil, &Error{Line: node.StartLine, Column: node.Column, Msg: fmt.Sprintf("Invalid instruction %q", instruction)}
+		}
+		node.Instruction = strings.ToUpper(instruction)
+		if node.Value == "" {
+			return nil, &Error{Line: node.StartLine, Column: node.Column + len(instruction), Msg: fmt.Sprintf("%s requires at least one argument", node.Instruction)}
+		}
+
+		node.Args = []string{node.Value}
+		if strings.HasPrefix(node.Value, "[") {
+			var args []string
+			if err := json.Unmarshal([]byte(node.Value), &args); err == nil {
+				node.Args = args
+				node.JSON = true
+			}
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
@@ -0,0 +1,64 @@
+package dockerfile
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	nodes, err := Parse(strings.NewReader(`# A comment
+from busybox
+
+run echo hello \
+    world
+	cmd ["/bin/echo", "hi"]
+entrypoint [not json
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 4 {
+		t.Fatalf("Expected 4 instructions, got %d", len(nodes))
+	}
+
+	expected := []Node{
+		{Instruction: "FROM", Value: "busybox", Args: []string{"busybox"}, StartLine: 2, EndLine: 2, Column: 1},
+		{Instruction: "RUN", Value: "echo hello    world", Args: []string{"echo hello    world"}, StartLine: 4, EndLine: 5, Column: 1},
+		{Instruction: "CMD", Value: `["/bin/echo", "hi"]`, Args: []string{"/bin/echo", "hi"}, JSON: true, StartLine: 6, EndLine: 6, Column: 2},
+		{Instruction: "ENTRYPOINT", Value: "[not json", Args: []string{"[not json"}, StartLine: 7, EndLine: 7, Column: 1},
+	}
+	for i, node := range nodes {
+		e := expected[i]
+		if node.Instruction != e.Instruction || node.Value != e.Value || node.JSON != e.JSON ||
+			node.StartLine != e.StartLine || node.EndLine != e.EndLine || node.Column != e.Column {
+			t.Fatalf("Expected %#v, got %#v", e, *node)
+		}
+		if strings.Join(node.Args, "|") != strings.Join(e.Args, "|") {
+			t.Fatalf("Expected the arguments %v, got %v", e.Args, node.Args)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for dockerfile, expected := range map[string]Error{
+		"from busybox\nrun\n":           {Line: 2, Column: 4, Msg: "RUN requires at least one argument"},
+		"from busybox\n\n  cmd  \n":     {Line: 3, Column: 6, Msg: "CMD requires at least one argument"},
+		"from busybox\n  ru-n foo\n":    {Line: 2, Column: 3, Msg: `Invalid instruction "ru-n"`},
+		"from busybox\nrun a \\\n\"b\"": {},
+	} {
+		_, err := Parse(strings.NewReader(dockerfile))
+		if expected.Line == 0 {
+			if err != nil {
+				t.Fatalf("Unexpected error parsing %q: %s", dockerfile, err)
+			}
+			continue
+		}
+		parseErr, ok := err.(*Error)
+		if !ok {
+			t.Fatalf("Expected a parse error for %q, got %v", dockerfile, err)
+		}
+		if *parseErr != expected {
+			t.Fatalf("Expected %s for %q, got %s", &expected, dockerfile, parseErr)
+		}
+	}
+}
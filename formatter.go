@@ -0,0 +1,213 @@
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/dotcloud/docker/utils"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+)
+
+// tableDirective, when it prefixes a -format value, tells the list
+// commands to print a header line and to align the columns.
+const tableDirective = "table"
+
+// formatFuncs are the helpers available to every -format template,
+// for inspect as well as for the list commands.
+var formatFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	},
+	"join":  strings.Join,
+	"split": strings.Split,
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"title": strings.Title,
+	"trunc": utils.Trunc,
+}
+
+// Escape sequences typed on the command line are not interpreted by the
+// shell, so we do it ourselves to let users separate columns with \t.
+var formatEscapes = strings.NewReplacer(`\t`, "\t", `\n`, "\n")
+
+func newFormatTemplate(format string) (*template.Template, error) {
+	return template.New("").Funcs(formatFuncs).Parse(formatEscapes.Replace(format))
+}
+
+func (cli *DockerCli) templateParseError(err error) error {
+	fmt.Fprintf(cli.err, "Template parsing error: %v\n", err)
+	return &utils.StatusError{StatusCode: 64,
+		Status: "Template parsing error: " + err.Error()}
+}
+
+// listFormatter renders the rows of a list command (ps, images, history)
+// through a user supplied template.
+type listFormatter struct {
+	out     io.Writer
+	tmpl    *template.Template
+	table   bool
+	headers map[string]string
+	started bool
+}
+
+// newListFormatter parses `format`. If it starts with the table directive,
+// the rest of the format is used as the row template and `headers`, which
+// maps each field name to its column title, is rendered through the same
+// template as the first line. The table directive alone selects
+// `defaultRow`.
+func newListFormatter(out io.Writer, format, defaultRow string, headers map[string]string) (*listFormatter, error) {
+	f := &listFormatter{out: out, headers: headers}
+	if strings.HasPrefix(format, tableDirective) {
+		f.table = true
+		format = strings.TrimSpace(strings.TrimPrefix(format, tableDirective))
+		if format == "" {
+			format = defaultRow
+		}
+		f.out = tabwriter.NewWriter(out, 20, 1, 3, ' ', 0)
+	}
+	tmpl, err := newFormatTemplate(format)
+	if err != nil {
+		return nil, err
+	}
+	f.tmpl = tmpl
+	return f, nil
+}
+
+func (f *listFormatter) writeHeader() error {
+	if f.started {
+		return nil
+	}
+	f.started = true
+	if !f.table {
+		return nil
+	}
+	if err := f.tmpl.Execute(f.out, f.headers); err != nil {
+		return err
+	}
+	_, err := f.out.Write([]byte{'\n'})
+	return err
+}
+
+// Write renders a single row.
+func (f *listFormatter) Write(row interface{}) error {
+	if err := f.writeHeader(); err != nil {
+		return err
+	}
+	if err := f.tmpl.Execute(f.out, row); err != nil {
+		return err
+	}
+	_, err := f.out.Write([]byte{'\n'})
+	return err
+}
+
+// Flush writes the header if no row has been written yet and aligns the
+// columns in table mode.
+func (f *listFormatter) Flush() error {
+	if err := f.writeHeader(); err != nil {
+		return err
+	}
+	if w, ok := f.out.(*tabwriter.Writer); ok {
+		return w.Flush()
+	}
+	return nil
+}
+
+// formatLabels returns the labels as a sorted, comma separated list of
+// key=value pairs
+func formatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for key, value := range labels {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// Fields available to `docker ps -format`
+type psRow struct {
+	ID           string
+	Image        string
+	Command      string
+	CreatedAt    string
+	CreatedSince string
+	Status       string
+	Ports        string
+	Names        string
+	Size         string
+	Labels       string
+}
+
+var (
+	psHeaders = map[string]string{
+		"ID":           "CONTAINER ID",
+		"Image":        "IMAGE",
+		"Command":      "COMMAND",
+		"CreatedAt":    "CREATED AT",
+		"CreatedSince": "CREATED",
+		"Status":       "STATUS",
+		"Ports":        "PORTS",
+		"Names":        "NAMES",
+		"Size":         "SIZE",
+		"Labels":       "LABELS",
+	}
+	psDefaultRow = "{{.ID}}\t{{.Image}}\t{{.Command}}\t{{.CreatedSince}}\t{{.Status}}\t{{.Ports}}\t{{.Names}}"
+)
+
+// Fields available to `docker images -format`
+type imagesRow struct {
+	Repository   string
+	Tag          string
+	Digest       string
+	ID           string
+	ParentID     string
+	CreatedAt    string
+	CreatedSince string
+	Size         string
+	VirtualSize  string
+	Labels       string
+}
+
+var (
+	imagesHeaders = map[string]string{
+		"Repository":   "REPOSITORY",
+		"Tag":          "TAG",
+		"Digest":       "DIGEST",
+		"ID":           "IMAGE ID",
+		"ParentID":     "PARENT ID",
+		"CreatedAt":    "CREATED AT",
+		"CreatedSince": "CREATED",
+		"Size":         "SIZE",
+		"VirtualSize":  "VIRTUAL SIZE",
+		"Labels":       "LABELS",
+	}
+	imagesDefaultRow = "{{.Repository}}\t{{.Tag}}\t{{.ID}}\t{{.CreatedSince}}\t{{.Size}}"
+)
+
+// Fields available to `docker history -format`
+type historyRow struct {
+	ID           string
+	Tags         string
+	CreatedAt    string
+	CreatedSince string
+	CreatedBy    string
+	Size         string
+}
+
+var (
+	historyHeaders = map[string]string{
+		"ID":           "IMAGE",
+		"Tags":         "TAGS",
+		"CreatedAt":    "CREATED AT",
+		"CreatedSince": "CREATED",
+		"CreatedBy":    "CREATED BY",
+		"Size":         "SIZE",
+	}
+	historyDefaultRow = "{{.ID}}\t{{.CreatedSince}}\t{{.CreatedBy}}\t{{.Size}}"
+)
@@ -0,0 +1,75 @@
+package docker
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestListFormatterPlain(t *testing.T) {
+	out := new(bytes.Buffer)
+	f, err := newListFormatter(out, "{{.ID}}: {{upper .Names}}", psDefaultRow, psHeaders)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range []psRow{{ID: "abc", Names: "foo"}, {ID: "def", Names: "bar,baz"}} {
+		if err := f.Write(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "abc: FOO\ndef: BAR,BAZ\n"; out.String() != expected {
+		t.Fatalf("Expected %q, got %q", expected, out.String())
+	}
+}
+
+func TestListFormatterTable(t *testing.T) {
+	out := new(bytes.Buffer)
+	f, err := newListFormatter(out, `table {{.Repository}}\t{{.Tag}}`, imagesDefaultRow, imagesHeaders)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Write(imagesRow{Repository: "busybox", Tag: "latest"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	expected := "REPOSITORY          TAG\n" +
+		"busybox             latest\n"
+	if out.String() != expected {
+		t.Fatalf("Expected %q, got %q", expected, out.String())
+	}
+}
+
+func TestListFormatterTableDefault(t *testing.T) {
+	out := new(bytes.Buffer)
+	f, err := newListFormatter(out, "table", historyDefaultRow, historyHeaders)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The headers are printed even when there is no row
+	if err := f.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	expected := "IMAGE               CREATED             CREATED BY          SIZE\n"
+	if out.String() != expected {
+		t.Fatalf("Expected %q, got %q", expected, out.String())
+	}
+}
+
+func TestListFormatterInvalid(t *testing.T) {
+	if _, err := newListFormatter(new(bytes.Buffer), "{{.ID", psDefaultRow, psHeaders); err == nil {
+		t.Fatal("Expected a template parsing error")
+	}
+}
+
+func TestFormatLabels(t *testing.T) {
+	if s := formatLabels(map[string]string{"team": "core", "owner": "ops"}); s != "owner=ops,team=core" {
+		t.Fatalf("Expected %q, got %q", "owner=ops,team=core", s)
+	}
+	if s := formatLabels(nil); s != "" {
+		t.Fatalf("Expected an empty string, got %q", s)
+	}
+}
@@ -129,9 +129,87 @@ func (graph *Graph) Create(layerData archive.Archive, container *Container, comm
 	return img, nil
 }
 
+// Squash creates a new image whose single layer holds all the changes made
+// by `img` and its ancestors on top of `parent`, which must be one of them.
+// A nil parent squashes the whole history into a base image.
+// The new image keeps the configuration of `img`.
+func (graph *Graph) Squash(img, parent *Image) (*Image, error) {
+	var layers int
+	found := parent == nil
+	if err := img.WalkHistory(func(i *Image) error {
+		if parent != nil && i.ID == parent.ID {
+			found = true
+			return io.EOF
+		}
+		layers++
+		return nil
+	}); err != nil && err != io.EOF {
+		return nil, err
+	}
+	if !found || layers == 0 {
+		return nil, fmt.Errorf("Bad parameter: %s is not an ancestor of %s", utils.TruncateID(parent.ID), utils.TruncateID(img.ID))
+	}
+
+	imgFs, err := graph.driver.Get(img.ID)
+	if err != nil {
+		return nil, fmt.Errorf("Driver %s failed to get image rootfs %s: %s", graph.driver, img.ID, err)
+	}
+	var (
+		layer    archive.Archive
+		parentID string
+		onto     = "scratch"
+	)
+	if parent == nil {
+		layer, err = archive.Tar(imgFs, archive.Uncompressed)
+	} else {
+		parentID, onto = parent.ID, utils.TruncateID(parent.ID)
+		var (
+			parentFs string
+			changes  []archive.Change
+		)
+		if parentFs, err = graph.driver.Get(parent.ID); err != nil {
+			return nil, fmt.Errorf("Driver %s failed to get image rootfs %s: %s", graph.driver, parent.ID, err)
+		}
+		if changes, err = archive.ChangesDirs(imgFs, parentFs); err != nil {
+			return nil, err
+		}
+		layer, err = archive.ExportChanges(imgFs, changes)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	comment := fmt.Sprintf("Squashed %d layers of %s onto %s", layers, utils.TruncateID(img.ID), onto)
+	if img.Comment != "" {
+		comment += "\n" + img.Comment
+	}
+	squashed := &Image{
+		ID:              GenerateID(),
+		Parent:          parentID,
+		Comment:         comment,
+		Created:         time.Now().UTC(),
+		ContainerConfig: img.ContainerConfig,
+		DockerVersion:   VERSION,
+		Author:          img.Author,
+		Config:          img.Config,
+		Architecture:    img.Architecture,
+	}
+	if err := graph.Register(nil, layer, squashed); err != nil {
+		return nil, err
+	}
+	return squashed, nil
+}
+
 // Register imports a pre-existing image into the graph.
 // FIXME: pass img as first argument
-func (graph *Graph) Register(jsonData []byte, layerData archive.Archive, img *Image) (err error) {
+func (graph *Graph) Register(jsonData []byte, layerData archive.Archive, img *Image) error {
+	return graph.RegisterChecked(jsonData, layerData, img, nil)
+}
+
+// RegisterChecked is Register, calling `check` once the layer is stored and
+// before the image is added to the graph. The image isn't registered if
+// `check` fails. It can store files in `root`, the directory of the image.
+func (graph *Graph) RegisterChecked(jsonData []byte, layerData archive.Archive, img *Image, check func(root string) error) (err error) {
 	defer func() {
 		// If any error occurs, remove the new dir from the driver.
 		// Don't check for errors since the dir might not have been created.
@@ -180,6 +258,11 @@ func (graph *Graph) Register(jsonData []byte, layerData archive.Archive, img *Im
 	if err := StoreImage(img, jsonData, layerData, tmp, rootfs); err != nil {
 		return err
 	}
+	if check != nil {
+		if err := check(tmp); err != nil {
+			return err
+		}
+	}
 	// Commit
 	if err := os.Rename(tmp, graph.imageRoot(img.ID)); err != nil {
 		return err
@@ -205,7 +288,7 @@ func (graph *Graph) TempLayerArchive(id string, compression archive.Compression,
 	if err != nil {
 		return nil, err
 	}
-	return archive.NewTempArchive(utils.ProgressReader(ioutil.NopCloser(a), 0, output, sf, true, "", "Buffering to disk"), tmp)
+	return archive.NewTempArchive(utils.ProgressReader(ioutil.NopCloser(a), 0, output, sf, true, utils.TruncateID(id), "Buffering to disk"), tmp)
 }
 
 // Mktemp creates a temporary sub-directory inside the graph's filesystem.
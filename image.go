@@ -28,6 +28,7 @@ type Image struct {
 	Author          string    `json:"author,omitempty"`
 	Config          *Config   `json:"config,omitempty"`
 	Architecture    string    `json:"architecture,omitempty"`
+	Checksum        string    `json:"-"`
 	graph           *Graph
 	Size            int64
 }
@@ -59,6 +60,14 @@ func LoadImage(root string) (*Image, error) {
 		img.Size = int64(size)
 	}
 
+	if buf, err := ioutil.ReadFile(path.Join(root, "checksum")); err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	} else {
+		img.Checksum = string(buf)
+	}
+
 	return img, nil
 }
 
@@ -139,6 +148,15 @@ func (img *Image) SaveSize(root string) error {
 	return nil
 }
 
+// SaveChecksum stores the `checksum` of the layer of `img` in the directory
+// `root`, next to its json which the checksum covers.
+func (img *Image) SaveChecksum(root string) error {
+	if err := ioutil.WriteFile(path.Join(root, "checksum"), []byte(img.Checksum), 0600); err != nil {
+		return fmt.Errorf("Error storing image checksum in %s/checksum: %s", root, err)
+	}
+	return nil
+}
+
 func jsonPath(root string) string {
 	return path.Join(root, "json")
 }
@@ -249,6 +267,16 @@ func (img *Image) getParentsSize(size int64) int64 {
 	return parentImage.getParentsSize(size)
 }
 
+// matchLabels returns true if the image config carries all the labels
+// described by `filters`
+func (img *Image) matchLabels(filters []string) bool {
+	var labels map[string]string
+	if img.Config != nil {
+		labels = img.Config.Labels
+	}
+	return matchLabels(labels, filters)
+}
+
 // Depth returns the number of parents for a
 // current image
 func (img *Image) Depth() (int, error) {
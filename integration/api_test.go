@@ -49,7 +49,7 @@ func TestGetInfo(t *testing.T) {
 	defer mkRuntimeFromEngine(eng, t).Nuke()
 	srv := mkServerFromEngine(eng, t)
 
-	initialImages, err := srv.Images(false, "")
+	initialImages, err := srv.Images(false, "", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -128,7 +128,7 @@ func TestGetImagesJSON(t *testing.T) {
 
 	// all=0
 
-	initialImages, err := srv.Images(false, "")
+	initialImages, err := srv.Images(false, "", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -169,7 +169,7 @@ func TestGetImagesJSON(t *testing.T) {
 
 	// all=1
 
-	initialImages, err = srv.Images(true, "")
+	initialImages, err = srv.Images(true, "", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -297,7 +297,7 @@ func TestGetContainersJSON(t *testing.T) {
 	defer mkRuntimeFromEngine(eng, t).Nuke()
 	srv := mkServerFromEngine(eng, t)
 
-	beginLen := len(srv.Containers(true, false, -1, "", ""))
+	beginLen := len(srv.Containers(true, false, -1, "", "", nil))
 
 	containerID := createTestContainer(eng, &docker.Config{
 		Image: unitTestImageID,
@@ -566,6 +566,50 @@ func TestPostCommit(t *testing.T) {
 	}
 }
 
+func TestPostBuildValidate(t *testing.T) {
+	eng := NewTestEngine(t)
+	defer mkRuntimeFromEngine(eng, t).Nuke()
+	srv := mkServerFromEngine(eng, t)
+
+	for dockerfile, expected := range map[string][]docker.APIDiagnostic{
+		"from busybox\nrun echo hello\n": {},
+		"from busybox\nfrobnicate all\nrun echo hello\n": {
+			{Line: 2, Column: 1, Severity: "warning", Message: "Unknown instruction FROBNICATE"},
+		},
+		"from busybox\n\n  run\n": {
+			{Line: 3, Column: 6, Severity: "error", Message: "RUN requires at least one argument"},
+		},
+		"run true\nexpose 80 http\n": {
+			{Line: 1, Column: 1, Severity: "warning", Message: "The first instruction must be FROM, found RUN"},
+			{Line: 2, Column: 1, Severity: "warning", Message: "Invalid port http: Invalid containerPort: http"},
+		},
+	} {
+		req, err := http.NewRequest("POST", "/build/validate", strings.NewReader(dockerfile))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r := httptest.NewRecorder()
+		if err := docker.ServeRequest(srv, docker.APIVERSION, r, req); err != nil {
+			t.Fatal(err)
+		}
+		assertHttpNotError(r, t)
+
+		diagnostics := []docker.APIDiagnostic{}
+		if err := json.Unmarshal(r.Body.Bytes(), &diagnostics); err != nil {
+			t.Fatal(err)
+		}
+		if len(diagnostics) != len(expected) {
+			t.Fatalf("Expected %v for %q, got %v", expected, dockerfile, diagnostics)
+		}
+		for i := range expected {
+			if diagnostics[i] != expected[i] {
+				t.Fatalf("Expected %v for %q, got %v", expected[i], dockerfile, diagnostics[i])
+			}
+		}
+	}
+}
+
 func TestPostContainersCreate(t *testing.T) {
 	eng := NewTestEngine(t)
 	defer mkRuntimeFromEngine(eng, t).Nuke()
@@ -1108,7 +1152,7 @@ func TestDeleteImages(t *testing.T) {
 	defer mkRuntimeFromEngine(eng, t).Nuke()
 	srv := mkServerFromEngine(eng, t)
 
-	initialImages, err := srv.Images(false, "")
+	initialImages, err := srv.Images(false, "", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1116,7 +1160,7 @@ func TestDeleteImages(t *testing.T) {
 	if err := srv.ContainerTag(unitTestImageName, "test", "test", false); err != nil {
 		t.Fatal(err)
 	}
-	images, err := srv.Images(false, "")
+	images, err := srv.Images(false, "", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1159,7 +1203,7 @@ func TestDeleteImages(t *testing.T) {
 	if len(outs) != 1 {
 		t.Fatalf("Expected %d event (untagged), got %d", 1, len(outs))
 	}
-	images, err = srv.Images(false, "")
+	images, err = srv.Images(false, "", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
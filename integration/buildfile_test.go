@@ -1,11 +1,15 @@
 package docker
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"github.com/dotcloud/docker"
 	"github.com/dotcloud/docker/archive"
+	"github.com/dotcloud/docker/dockerfile"
 	"github.com/dotcloud/docker/engine"
 	"github.com/dotcloud/docker/utils"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
@@ -119,6 +123,27 @@ run [ "$(cat /somewheeeere/over/the/rainbooow/ga)" = "bu" ]
 	{
 		`
 from {IMAGE}
+add d /d
+run [ "$(cat /d/ga)" = "bu" ]
+run [ ! -e /d/a.log ]
+run [ "$(cat /d/keep.log)" = "kept" ]
+add . /ctx
+run [ "$(cat /ctx/d/ga)" = "bu" ]
+run [ ! -e /ctx/secret ]
+`,
+		[][2]string{
+			{".dockerignore", "secret\nd/*.log\n!d/keep.log\n"},
+			{"d/ga", "bu"},
+			{"d/a.log", "ignored"},
+			{"d/keep.log", "kept"},
+			{"secret", "ignored"},
+		},
+		nil,
+	},
+
+	{
+		`
+from {IMAGE}
 add http://{SERVERADDR}/x /a/b/c
 run [ "$(cat /a/b/c)" = "hello" ]
 add http://{SERVERADDR}/x?foo=bar /
@@ -266,7 +291,7 @@ func buildImage(context testContextTemplate, t *testing.T, eng *engine.Engine, u
 	}
 	dockerfile := constructDockerfile(context.dockerfile, ip, port)
 
-	buildfile := docker.NewBuildFile(srv, ioutil.Discard, ioutil.Discard, false, useCache, false, ioutil.Discard, utils.NewStreamFormatter(false))
+	buildfile := docker.NewBuildFile(srv, ioutil.Discard, ioutil.Discard, false, useCache, false, "", nil, "", ioutil.Discard, utils.NewStreamFormatter(false))
 	id, err := buildfile.Build(mkTestContext(dockerfile, context.files, t))
 	if err != nil {
 		return nil, err
@@ -345,6 +370,28 @@ func TestBuildEnv(t *testing.T) {
 	}
 }
 
+func TestBuildLabel(t *testing.T) {
+	img, err := buildImage(testContextTemplate{`
+        from {IMAGE}
+        label team=core service="api gateway"
+        label owner=ops
+        `,
+		nil, nil}, t, nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{"team": "core", "service": "api gateway", "owner": "ops"}
+	if len(img.Config.Labels) != len(expected) {
+		t.Fatalf("Expected %d labels, found %v", len(expected), img.Config.Labels)
+	}
+	for key, value := range expected {
+		if img.Config.Labels[key] != value {
+			t.Fatalf("Expected label %s=%s, found %v", key, value, img.Config.Labels)
+		}
+	}
+}
+
 func TestBuildCmd(t *testing.T) {
 	img, err := buildImage(testContextTemplate{`
         from {IMAGE}
@@ -454,6 +501,38 @@ func TestBuildImageWithCache(t *testing.T) {
 	}
 }
 
+func TestBuildADDWithCache(t *testing.T) {
+	eng := NewTestEngine(t)
+	defer nuke(mkRuntimeFromEngine(eng, t))
+
+	dockerfile := `
+        from {IMAGE}
+        add foo /usr/lib/foo
+        run cat /usr/lib/foo
+        `
+	img, err := buildImage(testContextTemplate{dockerfile, [][2]string{{"foo", "hello"}}, nil}, t, eng, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imageId := img.ID
+
+	img, err = buildImage(testContextTemplate{dockerfile, [][2]string{{"foo", "hello"}}, nil}, t, eng, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imageId != img.ID {
+		t.Fatalf("Image ids should match: %s != %s", imageId, img.ID)
+	}
+
+	img, err = buildImage(testContextTemplate{dockerfile, [][2]string{{"foo", "world"}}, nil}, t, eng, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imageId == img.ID {
+		t.Fatalf("Image ids should not match when the added file changed: %s == %s", imageId, img.ID)
+	}
+}
+
 func TestBuildImageWithoutCache(t *testing.T) {
 	eng := NewTestEngine(t)
 	defer nuke(mkRuntimeFromEngine(eng, t))
@@ -516,7 +595,7 @@ func TestForbiddenContextPath(t *testing.T) {
 	}
 	dockerfile := constructDockerfile(context.dockerfile, ip, port)
 
-	buildfile := docker.NewBuildFile(srv, ioutil.Discard, ioutil.Discard, false, true, false, ioutil.Discard, utils.NewStreamFormatter(false))
+	buildfile := docker.NewBuildFile(srv, ioutil.Discard, ioutil.Discard, false, true, false, "", nil, "", ioutil.Discard, utils.NewStreamFormatter(false))
 	_, err = buildfile.Build(mkTestContext(dockerfile, context.files, t))
 
 	if err == nil {
@@ -524,7 +603,7 @@ func TestForbiddenContextPath(t *testing.T) {
 		t.Fail()
 	}
 
-	if err.Error() != "Forbidden path outside the build context: ../../ (/)" {
+	if err.Error() != "Dockerfile line 4: Forbidden path outside the build context: ../../ (/)" {
 		t.Logf("Error message is not expected: %s", err.Error())
 		t.Fail()
 	}
@@ -562,7 +641,7 @@ func TestBuildADDFileNotFound(t *testing.T) {
 	}
 	dockerfile := constructDockerfile(context.dockerfile, ip, port)
 
-	buildfile := docker.NewBuildFile(mkServerFromEngine(eng, t), ioutil.Discard, ioutil.Discard, false, true, false, ioutil.Discard, utils.NewStreamFormatter(false))
+	buildfile := docker.NewBuildFile(mkServerFromEngine(eng, t), ioutil.Discard, ioutil.Discard, false, true, false, "", nil, "", ioutil.Discard, utils.NewStreamFormatter(false))
 	_, err = buildfile.Build(mkTestContext(dockerfile, context.files, t))
 
 	if err == nil {
@@ -570,7 +649,7 @@ func TestBuildADDFileNotFound(t *testing.T) {
 		t.Fail()
 	}
 
-	if err.Error() != "foo: no such file or directory" {
+	if err.Error() != "Dockerfile line 3: foo: no such file or directory" {
 		t.Logf("Error message is not expected: %s", err.Error())
 		t.Fail()
 	}
@@ -630,3 +709,283 @@ func TestBuildFails(t *testing.T) {
 		t.Fatalf("StatusCode %d unexpected, should be 23", sterr.Code)
 	}
 }
+
+func TestBuildSyntaxError(t *testing.T) {
+	_, err := buildImage(testContextTemplate{`
+from {IMAGE}
+run echo hello
+ru-n echo world
+`,
+		nil, nil}, t, nil, true)
+
+	syntaxErr, ok := err.(*dockerfile.Error)
+	if !ok {
+		t.Fatalf("Expected a syntax error, got %v", err)
+	}
+	if syntaxErr.Line != 4 || syntaxErr.Column != 1 {
+		t.Fatalf("Expected the error at line 4, column 1, got %s", syntaxErr)
+	}
+}
+
+func TestBuildDockerfileName(t *testing.T) {
+	eng := NewTestEngine(t)
+	defer nuke(mkRuntimeFromEngine(eng, t))
+	srv := mkServerFromEngine(eng, t)
+
+	files := [][2]string{
+		{"images/api/Dockerfile", fmt.Sprintf("from %s\nmaintainer api\n", unitTestImageID)},
+		{"images/broken/Dockerfile", fmt.Sprintf("from %s\nadd missing /\n", unitTestImageID)},
+	}
+	build := func(dockerfileName string) (string, error) {
+		buildfile := docker.NewBuildFile(srv, ioutil.Discard, ioutil.Discard, false, true, false, dockerfileName, nil, "", ioutil.Discard, utils.NewStreamFormatter(false))
+		return buildfile.Build(mkTestContext("from scratch\n", files, t))
+	}
+
+	id, err := build("images/api/Dockerfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := srv.ImageInspect(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Author != "api" {
+		t.Fatalf("Expected the image to be built from images/api/Dockerfile, got the author %q", img.Author)
+	}
+
+	if _, err := build("images/broken/Dockerfile"); err == nil || err.Error() != "images/broken/Dockerfile line 2: missing: no such file or directory" {
+		t.Fatalf("Expected the name of the Dockerfile in the error, got %v", err)
+	}
+	for _, name := range []string{"../../etc/passwd", "/etc/passwd", "images/../../../etc/passwd"} {
+		if _, err := build(name); err == nil || err.Error() != "Cannot locate the Dockerfile "+name+" in the build context" {
+			t.Fatalf("Expected the Dockerfile %s outside of the context to be refused as missing, got %v", name, err)
+		}
+	}
+	if _, err := build("images/web/Dockerfile"); err == nil || err.Error() != "Cannot locate the Dockerfile images/web/Dockerfile in the build context" {
+		t.Fatalf("Expected a missing Dockerfile to be reported, got %v", err)
+	}
+}
+
+func TestBuildArgs(t *testing.T) {
+	eng := NewTestEngine(t)
+	defer nuke(mkRuntimeFromEngine(eng, t))
+	srv := mkServerFromEngine(eng, t)
+
+	dockerfile := fmt.Sprintf(`
+from %s
+arg greeting=hello
+arg target
+arg expected
+workdir /$target
+run [ "$greeting" = "$expected" ]
+env fromarg $greeting
+`, unitTestImageID)
+	build := func(buildArgs map[string]string) *docker.Image {
+		buildfile := docker.NewBuildFile(srv, ioutil.Discard, ioutil.Discard, false, true, false, "", buildArgs, "", ioutil.Discard, utils.NewStreamFormatter(false))
+		id, err := buildfile.Build(mkTestContext(dockerfile, nil, t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := srv.ImageInspect(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return img
+	}
+
+	img := build(map[string]string{"target": "srv", "expected": "hello"})
+	if img.Config.WorkingDir != "/srv" {
+		t.Fatalf("Expected the working directory /srv, got %s", img.Config.WorkingDir)
+	}
+	for _, env := range img.Config.Env {
+		if strings.HasPrefix(env, "greeting=") || strings.HasPrefix(env, "target=") {
+			t.Fatalf("The build arguments shouldn't be persisted in the image, found %s", env)
+		}
+	}
+	if env := strings.Join(img.Config.Env, " "); !strings.Contains(env, "fromarg=hello") {
+		t.Fatalf("Expected fromarg=hello in the environment, got %s", env)
+	}
+
+	if cached := build(map[string]string{"target": "srv", "expected": "hello"}); cached.ID != img.ID {
+		t.Fatalf("Expected the same build arguments to use the cache: %s != %s", cached.ID, img.ID)
+	}
+	if changed := build(map[string]string{"target": "srv", "greeting": "bonjour", "expected": "bonjour"}); changed.ID == img.ID {
+		t.Fatalf("Expected different build arguments to invalidate the cache")
+	}
+}
+
+func TestBuildOnBuildTriggers(t *testing.T) {
+	eng := NewTestEngine(t)
+	defer nuke(mkRuntimeFromEngine(eng, t))
+
+	parent, err := buildImage(testContextTemplate{`
+from {IMAGE}
+onbuild add foo /app/foo
+onbuild run [ "$(cat /app/foo)" = "hello" ] && touch /app/built
+`,
+		nil, nil}, t, eng, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parent.Config.OnBuild) != 2 || parent.Config.OnBuild[0] != "ADD foo /app/foo" {
+		t.Fatalf("Unexpected triggers %v", parent.Config.OnBuild)
+	}
+
+	child, err := buildImage(testContextTemplate{fmt.Sprintf(`
+from %s
+run [ -f /app/built ]
+`, parent.ID),
+		[][2]string{{"foo", "hello"}}, nil}, t, eng, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(child.Config.OnBuild) != 0 {
+		t.Fatalf("The triggers shouldn't be inherited, got %v", child.Config.OnBuild)
+	}
+
+	for _, trigger := range []string{"onbuild onbuild run true", "onbuild from busybox"} {
+		_, err := buildImage(testContextTemplate{"from {IMAGE}\n" + trigger + "\n", nil, nil}, t, eng, true)
+		if err == nil || !strings.Contains(err.Error(), "isn't allowed as an ONBUILD trigger") {
+			t.Fatalf("Expected %q to be refused, got %v", trigger, err)
+		}
+	}
+}
+
+func TestBuildMultiStage(t *testing.T) {
+	eng := NewTestEngine(t)
+	defer nuke(mkRuntimeFromEngine(eng, t))
+
+	img, err := buildImage(testContextTemplate{`
+arg base={IMAGE}
+from $base AS builder
+run mkdir -p /build/out && echo artifact > /build/out/app && touch /build/leftover && ln -s /build/out /linked
+maintainer builder
+env base nonexistent
+arg secret=leaked
+
+from $base as second
+copy --from=builder /build/out /opt/
+copy foo /opt/foo.tar
+
+from $base
+arg base
+copy --from=builder /linked/app /usr/bin/app
+copy --from=1 /opt/foo.tar /tmp/
+run [ "$(cat /usr/bin/app)" = "artifact" ] && [ ! -e /build ] && [ "$(cat /tmp/foo.tar)" = "not an archive" ]
+run [ -z "$secret" ] && [ "$base" = "{IMAGE}" ]
+`,
+		[][2]string{{"foo.tar", "not an archive"}}, nil}, t, eng, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Author != "" {
+		t.Fatalf("The final stage shouldn't inherit the maintainer of the first one, got %s", img.Author)
+	}
+
+	for _, c := range [][2]string{
+		{"from {IMAGE}\ncopy --from=builder /bin/sh /\n", "No such build stage: builder"},
+		{"from {IMAGE} as first\ncopy --from=first /bin/sh /\n", "The build stage first isn't a previous stage"},
+		{"from {IMAGE} as a\nfrom {IMAGE} as a\n", "Duplicate build stage name: a"},
+		{"from {IMAGE} as a\nfrom {IMAGE}\ncopy --from=a /../../nonexistent /\n", "/../../nonexistent: no such file or directory in the stage"},
+	} {
+		_, err := buildImage(testContextTemplate{c[0], nil, nil}, t, eng, true)
+		if err == nil || !strings.Contains(err.Error(), c[1]) {
+			t.Fatalf("Expected %q for %q, got %v", c[1], c[0], err)
+		}
+	}
+}
+
+func TestBuildEvents(t *testing.T) {
+	eng := NewTestEngine(t)
+	defer nuke(mkRuntimeFromEngine(eng, t))
+	srv := mkServerFromEngine(eng, t)
+
+	build := func(dockerfile string) ([]utils.JSONBuildEvent, error) {
+		out := bytes.NewBuffer(nil)
+		buildfile := docker.NewBuildFile(srv, ioutil.Discard, ioutil.Discard, false, true, false, "", nil, "", out, utils.NewStreamFormatter(true))
+		_, err := buildfile.Build(mkTestContext(dockerfile, nil, t))
+
+		var events []utils.JSONBuildEvent
+		dec := json.NewDecoder(out)
+		for {
+			var jm utils.JSONMessage
+			if err := dec.Decode(&jm); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if jm.BuildEvent != nil {
+				events = append(events, *jm.BuildEvent)
+			}
+		}
+		return events, err
+	}
+
+	dockerfile := fmt.Sprintf("from %s\nrun echo hello\n", unitTestImageID)
+	events, err := build(dockerfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kinds []string
+	for _, event := range events {
+		kinds = append(kinds, fmt.Sprintf("%d:%s", event.Step, event.Event))
+	}
+	if strings.Join(kinds, " ") != "1:step 1:step-done 2:step 2:cache-miss 2:container 2:step-done" {
+		t.Fatalf("Unexpected build events %v", kinds)
+	}
+	if events[2].Line != 2 || events[2].Instruction != "RUN echo hello" || events[5].ImageID == "" {
+		t.Fatalf("Unexpected build events %v", events)
+	}
+
+	if events, err = build(dockerfile); err != nil {
+		t.Fatal(err)
+	}
+	if events[3].Event != "cache-hit" || events[3].ImageID != events[4].ImageID {
+		t.Fatalf("Expected the RUN to be cached, got %v", events)
+	}
+
+	events, err = build(fmt.Sprintf("from %s\nrun exit 23\n", unitTestImageID))
+	if err == nil {
+		t.Fatal("Expected the build to fail")
+	}
+	if last := events[len(events)-1]; last.Event != "error" || last.Step != 2 || last.Code != 23 {
+		t.Fatalf("Expected an error event for the step 2, got %v", last)
+	}
+}
+
+func TestBuildComment(t *testing.T) {
+	eng := NewTestEngine(t)
+	defer nuke(mkRuntimeFromEngine(eng, t))
+	srv := mkServerFromEngine(eng, t)
+
+	dockerfile := fmt.Sprintf("from %s\nrun touch /built\n", unitTestImageID)
+	build := func(comment string) *docker.Image {
+		buildfile := docker.NewBuildFile(srv, ioutil.Discard, ioutil.Discard, false, true, false, "", nil, comment, ioutil.Discard, utils.NewStreamFormatter(false))
+		id, err := buildfile.Build(mkTestContext(dockerfile, nil, t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := srv.ImageInspect(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return img
+	}
+
+	first := build("Built from github.com/dotcloud/hello at commit 1111")
+	// The steps are all taken from the cache
+	second := build("Built from github.com/dotcloud/hello at commit 2222")
+	if first.Comment != "Built from github.com/dotcloud/hello at commit 1111" {
+		t.Fatalf("Expected the comment of the first build, got %q", first.Comment)
+	}
+	if second.Comment != "Built from github.com/dotcloud/hello at commit 2222" {
+		t.Fatalf("Expected the comment of the second build, got %q", second.Comment)
+	}
+	if first.Parent != second.Parent {
+		t.Fatalf("Expected the comments on top of the same cached image, got %s and %s", first.Parent, second.Parent)
+	}
+	// Building the same commit again gives the same image
+	if again := build("Built from github.com/dotcloud/hello at commit 1111"); again.ID != first.ID {
+		t.Fatalf("Expected the image %s of the same commit to be reused, got %s", first.ID, again.ID)
+	}
+}
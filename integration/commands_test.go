@@ -2,6 +2,7 @@ package docker
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
 	"github.com/dotcloud/docker"
 	"github.com/dotcloud/docker/engine"
@@ -969,3 +970,47 @@ func TestRunCidFile(t *testing.T) {
 	})
 
 }
+
+func TestBuildCheck(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	stdin := strings.NewReader("from busybox\nworkdir app\n")
+	cli := docker.NewDockerCli(ioutil.NopCloser(stdin), stdout, ioutil.Discard, testDaemonProto, testDaemonAddr)
+	defer cleanup(globalEngine, t)
+
+	if err := cli.CmdBuild("-check", "-"); err != nil {
+		t.Fatal(err)
+	}
+	expected := "Dockerfile line 2, column 1: warning: WORKDIR app isn't an absolute path\n"
+	if stdout.String() != expected {
+		t.Fatalf("Expected %q, got %q", expected, stdout.String())
+	}
+
+	context, err := ioutil.TempDir("", "docker-test-check")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(context)
+	if err := ioutil.WriteFile(path.Join(context, "Dockerfile.dev"), []byte("from busybox\nrun\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(context, "Dockerfile"), []byte("from busybox\nexpose http\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	stdout.Reset()
+	if err := cli.CmdBuild("-check", context); err != nil {
+		t.Fatal(err)
+	}
+	expected = "Dockerfile line 2, column 1: warning: Invalid port http: Invalid containerPort: http\n"
+	if stdout.String() != expected {
+		t.Fatalf("Expected %q, got %q", expected, stdout.String())
+	}
+
+	stdout.Reset()
+	if err := cli.CmdBuild("-check", "-f", path.Join(context, "Dockerfile.dev"), context); err == nil {
+		t.Fatal("Expected an error for a syntax error")
+	}
+	expected = "Dockerfile.dev line 2, column 4: error: RUN requires at least one argument\n"
+	if stdout.String() != expected {
+		t.Fatalf("Expected %q, got %q", expected, stdout.String())
+	}
+}
@@ -52,7 +52,7 @@ func cleanup(eng *engine.Engine, t *testing.T) error {
 		runtime.Destroy(container)
 	}
 	srv := mkServerFromEngine(eng, t)
-	images, err := srv.Images(true, "")
+	images, err := srv.Images(true, "", nil)
 	if err != nil {
 		return err
 	}
@@ -1,9 +1,17 @@
 package docker
 
 import (
+	"bytes"
 	"github.com/dotcloud/docker"
+	"github.com/dotcloud/docker/archive"
+	"github.com/dotcloud/docker/auth"
+	"github.com/dotcloud/docker/registry"
 	"github.com/dotcloud/docker/utils"
+	"io"
 	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path"
 	"strings"
 	"testing"
 )
@@ -14,7 +22,7 @@ func TestContainerTagImageDelete(t *testing.T) {
 
 	srv := mkServerFromEngine(eng, t)
 
-	initialImages, err := srv.Images(false, "")
+	initialImages, err := srv.Images(false, "", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -29,7 +37,7 @@ func TestContainerTagImageDelete(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	images, err := srv.Images(false, "")
+	images, err := srv.Images(false, "", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -42,7 +50,7 @@ func TestContainerTagImageDelete(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	images, err = srv.Images(false, "")
+	images, err = srv.Images(false, "", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -55,7 +63,7 @@ func TestContainerTagImageDelete(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	images, err = srv.Images(false, "")
+	images, err = srv.Images(false, "", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -68,7 +76,7 @@ func TestContainerTagImageDelete(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	images, err = srv.Images(false, "")
+	images, err = srv.Images(false, "", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -90,7 +98,7 @@ func TestCreateRm(t *testing.T) {
 
 	id := createTestContainer(eng, config, t)
 
-	if c := srv.Containers(true, false, -1, "", ""); len(c) != 1 {
+	if c := srv.Containers(true, false, -1, "", "", nil); len(c) != 1 {
 		t.Errorf("Expected 1 container, %v found", len(c))
 	}
 
@@ -98,7 +106,7 @@ func TestCreateRm(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if c := srv.Containers(true, false, -1, "", ""); len(c) != 0 {
+	if c := srv.Containers(true, false, -1, "", "", nil); len(c) != 0 {
 		t.Errorf("Expected 0 container, %v found", len(c))
 	}
 
@@ -116,7 +124,7 @@ func TestCreateRmVolumes(t *testing.T) {
 
 	id := createTestContainer(eng, config, t)
 
-	if c := srv.Containers(true, false, -1, "", ""); len(c) != 1 {
+	if c := srv.Containers(true, false, -1, "", "", nil); len(c) != 1 {
 		t.Errorf("Expected 1 container, %v found", len(c))
 	}
 
@@ -137,7 +145,7 @@ func TestCreateRmVolumes(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if c := srv.Containers(true, false, -1, "", ""); len(c) != 0 {
+	if c := srv.Containers(true, false, -1, "", "", nil); len(c) != 0 {
 		t.Errorf("Expected 0 container, %v found", len(c))
 	}
 }
@@ -171,7 +179,7 @@ func TestCreateStartRestartStopStartKillRm(t *testing.T) {
 
 	id := createTestContainer(eng, config, t)
 
-	if c := srv.Containers(true, false, -1, "", ""); len(c) != 1 {
+	if c := srv.Containers(true, false, -1, "", "", nil); len(c) != 1 {
 		t.Errorf("Expected 1 container, %v found", len(c))
 	}
 
@@ -208,7 +216,7 @@ func TestCreateStartRestartStopStartKillRm(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if c := srv.Containers(true, false, -1, "", ""); len(c) != 0 {
+	if c := srv.Containers(true, false, -1, "", "", nil); len(c) != 0 {
 		t.Errorf("Expected 0 container, %v found", len(c))
 	}
 }
@@ -235,7 +243,7 @@ func TestRmi(t *testing.T) {
 	srv := mkServerFromEngine(eng, t)
 	defer mkRuntimeFromEngine(eng, t).Nuke()
 
-	initialImages, err := srv.Images(false, "")
+	initialImages, err := srv.Images(false, "", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -290,7 +298,7 @@ func TestRmi(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	images, err := srv.Images(false, "")
+	images, err := srv.Images(false, "", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -304,7 +312,7 @@ func TestRmi(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	images, err = srv.Images(false, "")
+	images, err = srv.Images(false, "", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -340,7 +348,7 @@ func TestImagesFilter(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	images, err := srv.Images(false, "utest*/*")
+	images, err := srv.Images(false, "utest*/*", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -349,7 +357,7 @@ func TestImagesFilter(t *testing.T) {
 		t.Fatal("incorrect number of matches returned")
 	}
 
-	images, err = srv.Images(false, "utest")
+	images, err = srv.Images(false, "utest", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -358,7 +366,7 @@ func TestImagesFilter(t *testing.T) {
 		t.Fatal("incorrect number of matches returned")
 	}
 
-	images, err = srv.Images(false, "utest*")
+	images, err = srv.Images(false, "utest*", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -367,7 +375,7 @@ func TestImagesFilter(t *testing.T) {
 		t.Fatal("incorrect number of matches returned")
 	}
 
-	images, err = srv.Images(false, "*5000*/*")
+	images, err = srv.Images(false, "*5000*/*", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -377,6 +385,417 @@ func TestImagesFilter(t *testing.T) {
 	}
 }
 
+func TestImagesDiff(t *testing.T) {
+	eng := NewTestEngine(t)
+	defer nuke(mkRuntimeFromEngine(eng, t))
+
+	srv := mkServerFromEngine(eng, t)
+
+	img, err := buildImage(testContextTemplate{`
+        from {IMAGE}
+        run sh -c 'echo hello > /hello'
+        run rm /etc/passwd
+        `, nil, nil}, t, eng, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := srv.ImagesDiff(unitTestImageID, img.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var foundHello, foundPasswd bool
+	for _, change := range changes {
+		switch change.Path {
+		case "/hello":
+			if change.Kind != archive.ChangeAdd || change.SizeDelta != 6 {
+				t.Fatalf("Expected /hello to be added with 6 bytes, got %#v", change)
+			}
+			foundHello = true
+		case "/etc/passwd":
+			if change.Kind != archive.ChangeDelete || change.SizeDelta >= 0 {
+				t.Fatalf("Expected /etc/passwd to be deleted, got %#v", change)
+			}
+			foundPasswd = true
+		}
+	}
+	if !foundHello || !foundPasswd {
+		t.Fatalf("Expected /hello and /etc/passwd in the changes, got %v", changes)
+	}
+
+	if _, err := srv.ImagesDiff(unitTestImageID, "thisimagedoesnotexist"); err == nil {
+		t.Fatal("Expected an error when diffing a non existing image")
+	}
+}
+
+func TestImageSquash(t *testing.T) {
+	eng := NewTestEngine(t)
+	runtime := mkRuntimeFromEngine(eng, t)
+	defer nuke(runtime)
+
+	srv := mkServerFromEngine(eng, t)
+
+	img, err := buildImage(testContextTemplate{`
+        from {IMAGE}
+        run sh -c 'echo hello > /hello'
+        run rm /etc/passwd
+        cmd ["cat", "/hello"]
+        `, nil, nil}, t, eng, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var id string
+	job := eng.Job("squash", img.ID)
+	job.Setenv("from", unitTestImageID)
+	job.Setenv("repo", "squashed")
+	job.Stdout.AddString(&id)
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	squashed, err := runtime.Graph().Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if squashed.Parent != unitTestImageID {
+		t.Fatalf("Expected the squashed image to be based on %s, got %s", unitTestImageID, squashed.Parent)
+	}
+	if squashed.Config == nil || strings.Join(squashed.Config.Cmd, " ") != "cat /hello" {
+		t.Fatalf("Expected the squashed image to keep its Cmd, got %#v", squashed.Config)
+	}
+	if !strings.HasPrefix(squashed.Comment, "Squashed 3 layers") {
+		t.Fatalf("Expected the squash to be recorded in the comment, got %q", squashed.Comment)
+	}
+	if history, err := srv.ImageHistory("squashed"); err != nil || history[0].ID != id {
+		t.Fatalf("Expected the squashed image to be tagged, got %v", err)
+	}
+
+	changes, err := srv.ImagesDiff(img.ID, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, change := range changes {
+		if change.Kind != archive.ChangeModify {
+			t.Fatalf("Expected the squashed image to have the same content, got %v", changes)
+		}
+	}
+
+	job = eng.Job("squash", unitTestImageID)
+	job.Setenv("from", img.ID)
+	if err := job.Run(); err == nil {
+		t.Fatal("Expected an error when squashing onto an image which is not an ancestor")
+	}
+}
+
+func TestImageExportLoad(t *testing.T) {
+	eng := NewTestEngine(t)
+	defer nuke(mkRuntimeFromEngine(eng, t))
+
+	srv := mkServerFromEngine(eng, t)
+
+	img, err := buildImage(testContextTemplate{`
+        from {IMAGE}
+        run sh -c 'echo hello > /hello'
+        `, nil, nil}, t, eng, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.ContainerTag(img.ID, "utest", "hello", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.ContainerTag(unitTestImageID, "utest", "base", false); err != nil {
+		t.Fatal(err)
+	}
+
+	archive := new(bytes.Buffer)
+	if err := srv.ImageExport([]string{"utest:hello", unitTestImageName}, archive); err != nil {
+		t.Fatal(err)
+	}
+
+	// The tag utest:base was not asked for, so it is not restored
+	if _, err := srv.ImageDelete("utest:base", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.ContainerTag(unitTestImageID, "utest", "hello", true); err != nil {
+		t.Fatal(err)
+	}
+
+	sf := utils.NewStreamFormatter(false)
+	out := new(bytes.Buffer)
+	if err := srv.ImageLoad(bytes.NewReader(archive.Bytes()), false, out, sf); err == nil || !strings.HasPrefix(err.Error(), "Conflict") {
+		t.Fatalf("Expected a conflict on utest:hello, got %v", err)
+	}
+	if err := srv.ImageLoad(bytes.NewReader(archive.Bytes()), true, out, sf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "Loaded utest:hello") {
+		t.Fatalf("Expected the restored tags to be reported, got %q", out.String())
+	}
+	if history, err := srv.ImageHistory("utest:hello"); err != nil || history[0].ID != img.ID {
+		t.Fatalf("Expected utest:hello to be restored to %s", img.ID)
+	}
+	if _, err := srv.ImageHistory("utest:base"); err == nil {
+		t.Fatal("Expected utest:base not to be restored")
+	}
+}
+
+func TestImageExportLoadChecksum(t *testing.T) {
+	eng := NewTestEngine(t)
+	runtime := mkRuntimeFromEngine(eng, t)
+	defer nuke(runtime)
+
+	srv := mkServerFromEngine(eng, t)
+
+	img, err := buildImage(testContextTemplate{`
+        from {IMAGE}
+        run sh -c 'echo hello > /hello'
+        `, nil, nil}, t, eng, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.ContainerTag(img.ID, "utest", "sum", false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Store the checksum of the layer, as a push or a pull does
+	root := path.Join(runtime.Graph().Root, img.ID)
+	jsonRaw, err := ioutil.ReadFile(path.Join(root, "json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	layer, err := img.TarLayer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tarsum := &utils.TarSum{Reader: layer}
+	if _, err := io.Copy(ioutil.Discard, tarsum); err != nil {
+		t.Fatal(err)
+	}
+	img.Checksum = tarsum.Sum(jsonRaw)
+	if err := img.SaveChecksum(root); err != nil {
+		t.Fatal(err)
+	}
+
+	saved := new(bytes.Buffer)
+	if err := srv.ImageExport([]string{"utest:sum"}, saved); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.ImageDelete("utest:sum", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.ImageLoad(bytes.NewReader(saved.Bytes()), false, ioutil.Discard, utils.NewStreamFormatter(false)); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := srv.ImageInspect(img.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Checksum != img.Checksum {
+		t.Fatalf("Expected the checksum %s to be restored, got %q", img.Checksum, loaded.Checksum)
+	}
+}
+
+func TestPushPullBuiltinRegistry(t *testing.T) {
+	eng := NewTestEngine(t)
+	defer nuke(mkRuntimeFromEngine(eng, t))
+
+	srv := mkServerFromEngine(eng, t)
+
+	root, err := ioutil.TempDir("", "docker-test-registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	reg, err := registry.NewServer(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpServer := httptest.NewServer(reg)
+	defer httpServer.Close()
+	name := strings.TrimPrefix(httpServer.URL, "http://") + "/utest/hello"
+
+	img, err := buildImage(testContextTemplate{`
+        from {IMAGE}
+        run sh -c 'echo hello > /hello'
+        `, nil, nil}, t, eng, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.ContainerTag(img.ID, name, "latest", false); err != nil {
+		t.Fatal(err)
+	}
+
+	sf := utils.NewStreamFormatter(false)
+	if err := srv.ImagePush(name, "", ioutil.Discard, sf, &auth.AuthConfig{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if pushed, err := srv.ImageInspect(img.ID); err != nil {
+		t.Fatal(err)
+	} else if pushed.Checksum == "" {
+		t.Fatal("Expected the checksum of the pushed layer to be stored")
+	}
+
+	// Pushing again only reuses the layers
+	out := bytes.NewBuffer(nil)
+	if err := srv.ImagePush(name, "", out, sf, &auth.AuthConfig{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "Pushed 0 layers (0 B), reused ") {
+		t.Fatalf("Expected the layers to be reused, got %s", out.String())
+	}
+	if strings.Contains(out.String(), "Buffering to disk") {
+		t.Fatalf("Expected no layer to be archived, got %s", out.String())
+	}
+
+	if _, err := srv.ImageDelete(name+":latest", false); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := srv.ImagePull(name, "latest", ioutil.Discard, sf, &auth.AuthConfig{}, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	history, err := srv.ImageHistory(name + ":latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if history[0].ID != img.ID {
+		t.Fatalf("Expected %s to be pulled back as %s, got %s", name, img.ID, history[0].ID)
+	}
+	pulled, err := srv.ImageInspect(img.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pulled.Checksum == "" {
+		t.Fatal("Expected the checksum of the pulled layer to be verified")
+	}
+}
+
+func TestPullByDigest(t *testing.T) {
+	eng := NewTestEngine(t)
+	defer nuke(mkRuntimeFromEngine(eng, t))
+
+	srv := mkServerFromEngine(eng, t)
+
+	root, err := ioutil.TempDir("", "docker-test-registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	reg, err := registry.NewServer(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpServer := httptest.NewServer(reg)
+	defer httpServer.Close()
+	name := strings.TrimPrefix(httpServer.URL, "http://") + "/utest/hello"
+
+	img, err := buildImage(testContextTemplate{`
+        from {IMAGE}
+        run sh -c 'echo hello > /hello'
+        `, nil, nil}, t, eng, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.ContainerTag(img.ID, name, "latest", false); err != nil {
+		t.Fatal(err)
+	}
+
+	sf := utils.NewStreamFormatter(false)
+	if err := srv.ImagePush(name, "", ioutil.Discard, sf, &auth.AuthConfig{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	images, err := srv.Images(false, name, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 1 || len(images[0].RepoDigests) != 1 {
+		t.Fatalf("Expected the digest of the pushed image, got %v", images)
+	}
+	repoDigest := images[0].RepoDigests[0]
+	_, digest := utils.ParseRepositoryRef(repoDigest)
+
+	// Move the tag: the digest still refers to the pushed content
+	if err := srv.ContainerTag(unitTestImageID, name, "latest", true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.ImageDelete(img.ID, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := srv.ImagePull(name, digest, ioutil.Discard, sf, &auth.AuthConfig{}, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	pulled, err := srv.ImageInspect(repoDigest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pulled.ID != img.ID {
+		t.Fatalf("Expected %s to be pulled back as %s, got %s", repoDigest, img.ID, pulled.ID)
+	}
+	createTestContainer(eng, &docker.Config{Image: repoDigest, Cmd: []string{"true"}}, t)
+
+	if err := srv.ImagePull(name, utils.Digest([]byte("unknown")), ioutil.Discard, sf, &auth.AuthConfig{}, nil, false); err == nil {
+		t.Fatal("Expected error pulling an unknown digest")
+	}
+}
+
+func TestPushPullDirectory(t *testing.T) {
+	eng := NewTestEngine(t)
+	defer nuke(mkRuntimeFromEngine(eng, t))
+
+	srv := mkServerFromEngine(eng, t)
+
+	root, err := ioutil.TempDir("", "docker-test-registry-dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	name := "dir:" + root + "/hello"
+
+	img, err := buildImage(testContextTemplate{`
+        from {IMAGE}
+        run sh -c 'echo hello > /hello'
+        `, nil, nil}, t, eng, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// "dir:///" names the same repository as "dir:/"
+	if err := srv.ContainerTag(img.ID, "dir://"+root+"/hello", "1.0", false); err != nil {
+		t.Fatal(err)
+	}
+
+	sf := utils.NewStreamFormatter(false)
+	if err := srv.ImagePush(name, "2.0", ioutil.Discard, sf, &auth.AuthConfig{}, nil); err == nil {
+		t.Fatalf("Expected pushing a missing tag to fail")
+	}
+	if err := srv.ImagePush(name, "1.0", ioutil.Discard, sf, &auth.AuthConfig{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path.Join(root, "images", img.ID, "layer")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.ImageDelete(name+":1.0", false); err != nil {
+		t.Fatal(err)
+	}
+
+	// The base image is already there, only the new layer is pulled
+	out := new(bytes.Buffer)
+	if err := srv.ImagePull("dir://"+root+"/hello", "1.0", out, sf, &auth.AuthConfig{}, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(out.String(), "Pulling fs layer") != 1 {
+		t.Fatalf("Expected only the new layer to be pulled, got %q", out.String())
+	}
+	history, err := srv.ImageHistory(name + ":1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if history[0].ID != img.ID {
+		t.Fatalf("Expected %s to be pulled back as %s, got %s", name, img.ID, history[0].ID)
+	}
+}
+
 func TestImageInsert(t *testing.T) {
 	eng := NewTestEngine(t)
 	defer mkRuntimeFromEngine(eng, t).Nuke()
@@ -17,7 +17,7 @@ func TestServerListOrderedImagesByCreationDate(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	images, err := srv.Images(true, "")
+	images, err := srv.Images(true, "", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -44,7 +44,7 @@ func TestServerListOrderedImagesByCreationDateAndTag(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	images, err := srv.Images(true, "")
+	images, err := srv.Images(true, "", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -3,6 +3,8 @@ package docker
 import (
 	"fmt"
 	"github.com/dotcloud/docker/utils"
+	"net"
+	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -128,6 +130,13 @@ func ValidateEnv(val string) (string, error) {
 	return fmt.Sprintf("%s=%s", val, os.Getenv(val)), nil
 }
 
+func ValidateLabel(val string) (string, error) {
+	if key, _ := parseLabel(val); strings.TrimSpace(key) == "" {
+		return val, fmt.Errorf("bad format for label: %s", val)
+	}
+	return val, nil
+}
+
 func ValidateHost(val string) (string, error) {
 	host, err := utils.ParseHost(DEFAULTHTTPHOST, DEFAULTHTTPPORT, val)
 	if err != nil {
@@ -136,6 +145,41 @@ func ValidateHost(val string) (string, error) {
 	return host, nil
 }
 
+// ValidateMirror checks that val is the http(s) URL of a registry mirror and
+// returns its API endpoint.
+func ValidateMirror(val string) (string, error) {
+	u, err := url.Parse(val)
+	if err != nil {
+		return val, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return val, fmt.Errorf("%s is not a http(s) URL", val)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/"
+	} else if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	return u.String(), nil
+}
+
+// ValidateInsecureRegistry checks that val is a CIDR or a host with an
+// optional port.
+func ValidateInsecureRegistry(val string) (string, error) {
+	if _, _, err := net.ParseCIDR(val); err == nil {
+		return val, nil
+	}
+	if val == "" || strings.Contains(val, "://") || strings.ContainsAny(val, "/ ") {
+		return val, fmt.Errorf("%s is not a host[:port] or a CIDR", val)
+	}
+	if strings.Contains(val, ":") {
+		if _, port, err := net.SplitHostPort(val); err != nil || port == "" {
+			return val, fmt.Errorf("%s is not a host[:port] or a CIDR", val)
+		}
+	}
+	return val, nil
+}
+
 func ValidateIp4Address(val string) (string, error) {
 	re := regexp.MustCompile(`^(([0-9]+\.){3}([0-9]+))\s*$`)
 	var ns = re.FindSubmatch([]byte(val))
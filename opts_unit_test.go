@@ -22,3 +22,37 @@ func TestValidateIP4(t *testing.T) {
 	}
 
 }
+
+func TestValidateInsecureRegistry(t *testing.T) {
+	for _, val := range []string{"registry.local", "registry.local:5000", "10.0.0.1", "10.0.0.0/8"} {
+		if ret, err := ValidateInsecureRegistry(val); err != nil || ret != val {
+			t.Fatalf("ValidateInsecureRegistry(`%s`) got %s %s", val, ret, err)
+		}
+	}
+
+	for _, val := range []string{"", "http://registry.local", "registry.local/foo", "registry.local:", "10.0.0.0/33"} {
+		if _, err := ValidateInsecureRegistry(val); err == nil {
+			t.Fatalf("ValidateInsecureRegistry(`%s`) should have failed", val)
+		}
+	}
+}
+
+func TestValidateMirror(t *testing.T) {
+	valid := map[string]string{
+		"http://mirror.local":           "http://mirror.local/v1/",
+		"https://mirror.local:5000/":    "https://mirror.local:5000/v1/",
+		"http://mirror.local/cache/v1":  "http://mirror.local/cache/v1/",
+		"http://mirror.local/cache/v1/": "http://mirror.local/cache/v1/",
+	}
+	for val, expected := range valid {
+		if ret, err := ValidateMirror(val); err != nil || ret != expected {
+			t.Fatalf("ValidateMirror(`%s`) got %s %s, expected %s", val, ret, err, expected)
+		}
+	}
+
+	for _, val := range []string{"mirror.local", "ftp://mirror.local", "http://"} {
+		if _, err := ValidateMirror(val); err == nil {
+			t.Fatalf("ValidateMirror(`%s`) should have failed", val)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package registry
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/dotcloud/docker/utils"
+	"io/ioutil"
+	"math/big"
+	"os"
+)
+
+var ErrManifestUnsigned = errors.New("The manifest is not signed")
+
+// Manifest lists the layers of a tagged image, from the base layer up, with
+// their checksum. Its digest addresses the content of the image, unlike the
+// random image IDs and the mutable tags. It is signed by the daemon which
+// pushed it, which detects a corrupted manifest but doesn't prove who
+// pushed it, as no signing key is trusted more than another.
+type Manifest struct {
+	SchemaVersion int                  `json:"schemaVersion"`
+	Name          string               `json:"name"`
+	Tag           string               `json:"tag"`
+	Layers        []*ManifestLayer     `json:"layers"`
+	Signatures    []*ManifestSignature `json:"signatures,omitempty"`
+}
+
+type ManifestLayer struct {
+	ID       string `json:"id"`
+	Checksum string `json:"checksum"`
+}
+
+// ManifestSignature is an ECDSA signature of the payload of a manifest.
+type ManifestSignature struct {
+	// The public key, PKIX DER encoded, in base64
+	Key string `json:"key"`
+	// The r and s of the signature of the sha256 of the payload, big endian
+	// and padded to the size of the curve, in base64
+	Signature string `json:"signature"`
+}
+
+// Payload returns the JSON of the manifest without its signatures: what
+// they sign, and what its digest is computed from, so that signing the
+// manifest again doesn't change its digest.
+func (m *Manifest) Payload() ([]byte, error) {
+	unsigned := *m
+	unsigned.Signatures = nil
+	return json.Marshal(&unsigned)
+}
+
+// Digest returns the digest of the payload of the manifest.
+func (m *Manifest) Digest() (string, error) {
+	payload, err := m.Payload()
+	if err != nil {
+		return "", err
+	}
+	return utils.Digest(payload), nil
+}
+
+// Sign adds the signature of the payload of the manifest by key.
+func (m *Manifest) Sign(key *ecdsa.PrivateKey) error {
+	payload, err := m.Payload()
+	if err != nil {
+		return err
+	}
+	pub, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return err
+	}
+	hash := sha256.Sum256(payload)
+	r, s, err := ecdsa.Sign(rand.Reader, key, hash[:])
+	if err != nil {
+		return err
+	}
+	size := curveSize(key.Curve)
+	sig := make([]byte, 2*size)
+	rBytes, sBytes := r.Bytes(), s.Bytes()
+	copy(sig[size-len(rBytes):size], rBytes)
+	copy(sig[2*size-len(sBytes):], sBytes)
+	m.Signatures = append(m.Signatures, &ManifestSignature{
+		Key:       base64.StdEncoding.EncodeToString(pub),
+		Signature: base64.StdEncoding.EncodeToString(sig),
+	})
+	return nil
+}
+
+// curveSize returns the size in bytes of the integers of curve, which r and
+// s are padded to in the signatures.
+func curveSize(curve elliptic.Curve) int {
+	return (curve.Params().BitSize + 7) / 8
+}
+
+// Verify checks the signatures of the manifest, and returns the IDs of the
+// keys which signed it. ErrManifestUnsigned is returned if it has none.
+// As the keys come with the manifest, this only guards against a corrupted
+// manifest: anyone can sign a forged one with their own key.
+func (m *Manifest) Verify() ([]string, error) {
+	if len(m.Signatures) == 0 {
+		return nil, ErrManifestUnsigned
+	}
+	payload, err := m.Payload()
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(payload)
+	var keyIDs []string
+	for _, s := range m.Signatures {
+		der, err := base64.StdEncoding.DecodeString(s.Key)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid manifest signature key: %s", err)
+		}
+		pub, err := x509.ParsePKIXPublicKey(der)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid manifest signature key: %s", err)
+		}
+		key, ok := pub.(*ecdsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("Invalid manifest signature key: not an ECDSA key")
+		}
+		sig, err := base64.StdEncoding.DecodeString(s.Signature)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid manifest signature: %s", err)
+		}
+		keyID := KeyID(der)
+		size := curveSize(key.Curve)
+		if len(sig) != 2*size {
+			return nil, fmt.Errorf("Invalid manifest signature by the key %s", keyID)
+		}
+		sigR, sigS := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(key, hash[:], sigR, sigS) {
+			return nil, fmt.Errorf("Invalid manifest signature by the key %s", keyID)
+		}
+		keyIDs = append(keyIDs, keyID)
+	}
+	return keyIDs, nil
+}
+
+// KeyID returns a short identifier of the PKIX DER encoded public key der.
+func KeyID(der []byte) string {
+	hash := sha256.Sum256(der)
+	return utils.TruncateID(hex.EncodeToString(hash[:]))
+}
+
+// LoadManifestKey loads the PEM encoded key signing the manifests from the
+// file at path, and generates it if there is none.
+func LoadManifestKey(path string) (*ecdsa.PrivateKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil || block.Type != "EC PRIVATE KEY" {
+			return nil, fmt.Errorf("Invalid manifest key %s", path)
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
@@ -0,0 +1,55 @@
+package registry
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestManifestSignature(t *testing.T) {
+	dir, err := ioutil.TempDir("", "docker-test-manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key, err := LoadManifestKey(path.Join(dir, "key.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadManifestKey(path.Join(dir, "key.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.D.Cmp(key.D) != 0 {
+		t.Fatal("Expected the generated key to be loaded again")
+	}
+
+	manifest := &Manifest{SchemaVersion: 1, Name: "foo/bar", Tag: "latest", Layers: []*ManifestLayer{{ID: "1", Checksum: "tarsum+sha256:1"}}}
+	if _, err := manifest.Verify(); err != ErrManifestUnsigned {
+		t.Fatalf("Expected %s, got %v", ErrManifestUnsigned, err)
+	}
+	digest, err := manifest.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := manifest.Sign(key); err != nil {
+		t.Fatal(err)
+	}
+	keyIDs, err := manifest.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keyIDs) != 1 || len(keyIDs[0]) != 12 {
+		t.Fatalf("Expected the ID of the signing key, got %v", keyIDs)
+	}
+	if signedDigest, err := manifest.Digest(); err != nil || signedDigest != digest {
+		t.Fatalf("Expected the signature to leave the digest %s unchanged, got %s (%v)", digest, signedDigest, err)
+	}
+
+	manifest.Layers[0].Checksum = "tarsum+sha256:2"
+	if _, err := manifest.Verify(); err == nil {
+		t.Fatal("Expected a modified manifest to fail the verification of its signature")
+	}
+}
@@ -13,9 +13,11 @@ import (
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
+	"path"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -23,6 +25,7 @@ var (
 	ErrAlreadyExists         = errors.New("Image already exists")
 	ErrInvalidRepositoryName = errors.New("Invalid repository name (ex: \"registry.domain.tld/myrepos\")")
 	ErrLoginRequired         = errors.New("Authentication is required.")
+	ErrManifestsUnsupported  = errors.New("The registry does not support manifests")
 )
 
 func pingRegistryEndpoint(endpoint string) error {
@@ -41,9 +44,20 @@ func pingRegistryEndpoint(endpoint string) error {
 		conn.SetDeadline(time.Now().Add(time.Duration(10) * time.Second))
 		return conn, nil
 	}
-	httpTransport := &http.Transport{Dial: httpDial}
+	req, err := http.NewRequest("GET", endpoint+"_ping", nil)
+	if err != nil {
+		return err
+	}
+	if err := checkScheme(req); err != nil {
+		return err
+	}
+	tlsConfig, err := newTLSConfig(req.URL.Host)
+	if err != nil {
+		return err
+	}
+	httpTransport := &http.Transport{Dial: httpDial, TLSClientConfig: tlsConfig}
 	client := &http.Client{Transport: httpTransport}
-	resp, err := client.Get(endpoint + "_ping")
+	resp, err := client.Do(req)
 	if err != nil {
 		return err
 	}
@@ -55,6 +69,79 @@ func pingRegistryEndpoint(endpoint string) error {
 	return nil
 }
 
+// mirrorCheckInterval is how long the health of a mirror is trusted before
+// it gets pinged again.
+const mirrorCheckInterval = 30 * time.Second
+
+// Mirror is a pull-through cache of the registries behind the official
+// index. Its health is tracked so that pulls skip it while it is down.
+type Mirror struct {
+	sync.Mutex
+	Endpoint  string
+	healthy   bool
+	lastCheck time.Time
+	lastErr   error
+}
+
+func NewMirror(endpoint string) *Mirror {
+	return &Mirror{Endpoint: endpoint}
+}
+
+// Healthy reports whether the mirror can be used, pinging it again if its
+// health has not been checked recently.
+func (m *Mirror) Healthy() bool {
+	m.Lock()
+	defer m.Unlock()
+	if time.Since(m.lastCheck) < mirrorCheckInterval {
+		return m.healthy
+	}
+	m.lastErr = pingRegistryEndpoint(m.Endpoint)
+	m.healthy = m.lastErr == nil
+	m.lastCheck = time.Now()
+	if !m.healthy {
+		utils.Debugf("Mirror %s is unreachable: %s", m.Endpoint, m.lastErr)
+	}
+	return m.healthy
+}
+
+// Fail marks the mirror as unhealthy until its next check, if err is a
+// failure of the mirror itself: a network error or a 5xx status. Any other
+// error, such as a 404 for an image it doesn't have or a layer which
+// doesn't match its checksum, leaves it healthy.
+func (m *Mirror) Fail(err error) {
+	switch e := err.(type) {
+	case *utils.JSONError:
+		if e.Code < 500 {
+			return
+		}
+	case *url.Error, net.Error:
+	default:
+		if err != io.ErrUnexpectedEOF {
+			return
+		}
+	}
+	m.Lock()
+	defer m.Unlock()
+	m.healthy = false
+	m.lastErr = err
+	m.lastCheck = time.Now()
+}
+
+// Status returns a short human readable description of the mirror health,
+// as of its last check.
+func (m *Mirror) Status() string {
+	m.Lock()
+	defer m.Unlock()
+	switch {
+	case m.lastCheck.IsZero():
+		return "Unchecked"
+	case m.healthy:
+		return "Healthy"
+	default:
+		return "Unhealthy: " + m.lastErr.Error()
+	}
+}
+
 func validateRepositoryName(repositoryName string) error {
 	var (
 		namespace string
@@ -79,8 +166,35 @@ func validateRepositoryName(repositoryName string) error {
 	return nil
 }
 
+// IsDirEndpoint returns true if endpoint is a registry stored in a local
+// directory, rather than served over http.
+func IsDirEndpoint(endpoint string) bool {
+	return strings.HasPrefix(endpoint, "dir:")
+}
+
+// resolveDirRepositoryName resolves "dir:/mnt/images/app" (or
+// "dir:///mnt/images/app") to the repository "app" of the registry stored
+// in /mnt/images.
+func resolveDirRepositoryName(reposName string) (string, string, error) {
+	dir := path.Clean(strings.TrimPrefix(reposName, "dir:"))
+	if !path.IsAbs(dir) {
+		return "", "", fmt.Errorf("Invalid repository name %s, the directory must be an absolute path", reposName)
+	}
+	dir, name := path.Split(dir)
+	if dir == "/" {
+		return "", "", fmt.Errorf("Invalid repository name %s, the registry can't be stored in /", reposName)
+	}
+	if err := validateRepositoryName(name); err != nil {
+		return "", "", err
+	}
+	return "dir://" + dir + "v1/", name, nil
+}
+
 // Resolves a repository name to a endpoint + name
 func ResolveRepositoryName(reposName string) (string, string, error) {
+	if IsDirEndpoint(reposName) {
+		return resolveDirRepositoryName(reposName)
+	}
 	if strings.Contains(reposName, "://") {
 		// It cannot contain a scheme!
 		return "", "", ErrInvalidRepositoryName
@@ -129,6 +243,9 @@ func ExpandAndVerifyRegistryUrl(hostname string) (string, error) {
 	}
 	endpoint := fmt.Sprintf("https://%s/v1/", hostname)
 	if err := pingRegistryEndpoint(endpoint); err != nil {
+		if !httpAllowed(hostname) {
+			return "", fmt.Errorf("Invalid Registry endpoint: %s. If %s has no trusted https certificate, start the daemon with -insecure-registry %s, or put its CA in the %s subdirectory of -registry-certs", err, hostname, hostname, hostname)
+		}
 		utils.Debugf("Registry %s does not work (%s), falling back to http", endpoint, err)
 		endpoint = fmt.Sprintf("http://%s/v1/", hostname)
 		if err = pingRegistryEndpoint(endpoint); err != nil {
@@ -188,18 +305,55 @@ func (r *Registry) GetRemoteHistory(imgID, registry string, token []string) ([]s
 
 // Check if an image exists in the Registry
 func (r *Registry) LookupRemoteImage(imgID, registry string, token []string) bool {
+	found, _ := r.lookupRemoteImage(imgID, registry, token)
+	return found
+}
 
+// lookupRemoteImage checks if an image exists in the registry, and returns
+// the checksum of its layer if the registry gives it.
+func (r *Registry) lookupRemoteImage(imgID, registry string, token []string) (bool, string) {
 	req, err := r.reqFactory.NewRequest("GET", registry+"images/"+imgID+"/json", nil)
 	if err != nil {
-		return false
+		return false, ""
 	}
 	req.Header.Set("Authorization", "Token "+strings.Join(token, ", "))
 	res, err := doWithCookies(r.client, req)
 	if err != nil {
-		return false
+		return false, ""
 	}
 	res.Body.Close()
-	return res.StatusCode == 200
+	return res.StatusCode == 200, res.Header.Get("X-Docker-Checksum")
+}
+
+// lookupConcurrency is the number of images looked up at the same time by
+// LookupRemoteImages
+const lookupConcurrency = 8
+
+// LookupRemoteImages checks which of imgIDs the registry already has. It
+// returns the checksums of their layers by id, empty when the registry
+// doesn't give them.
+func (r *Registry) LookupRemoteImages(imgIDs []string, registry string, token []string) map[string]string {
+	var (
+		lock   sync.Mutex
+		wg     sync.WaitGroup
+		slots  = make(chan struct{}, lookupConcurrency)
+		exists = make(map[string]string)
+	)
+	for _, id := range imgIDs {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			if found, checksum := r.lookupRemoteImage(id, registry, token); found {
+				lock.Lock()
+				exists[id] = checksum
+				lock.Unlock()
+			}
+		}(id)
+	}
+	wg.Wait()
+	return exists
 }
 
 // Retrieve an image from the Registry.
@@ -212,7 +366,7 @@ func (r *Registry) GetRemoteImageJSON(imgID, registry string, token []string) ([
 	req.Header.Set("Authorization", "Token "+strings.Join(token, ", "))
 	res, err := doWithCookies(r.client, req)
 	if err != nil {
-		return nil, -1, fmt.Errorf("Failed to download json: %s", err)
+		return nil, -1, err
 	}
 	defer res.Body.Close()
 	if res.StatusCode != 200 {
@@ -231,20 +385,36 @@ func (r *Registry) GetRemoteImageJSON(imgID, registry string, token []string) ([
 	return jsonString, imageSize, nil
 }
 
-func (r *Registry) GetRemoteImageLayer(imgID, registry string, token []string) (io.ReadCloser, error) {
+// GetRemoteImageLayer returns the layer of imgID, starting at byte offset.
+// A non-zero offset is requested with a Range header, to resume an
+// interrupted download. If the registry ignores it, the first bytes of
+// the layer are skipped here.
+func (r *Registry) GetRemoteImageLayer(imgID, registry string, token []string, offset int64) (io.ReadCloser, error) {
 	req, err := r.reqFactory.NewRequest("GET", registry+"images/"+imgID+"/layer", nil)
 	if err != nil {
 		return nil, fmt.Errorf("Error while getting from the server: %s\n", err)
 	}
 	req.Header.Set("Authorization", "Token "+strings.Join(token, ", "))
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
 	res, err := doWithCookies(r.client, req)
 	if err != nil {
 		return nil, err
 	}
-	if res.StatusCode != 200 {
+	switch {
+	case res.StatusCode == 206 && offset > 0:
+	case res.StatusCode == 200:
+		if offset > 0 {
+			if _, err := io.CopyN(ioutil.Discard, res.Body, offset); err != nil {
+				res.Body.Close()
+				return nil, err
+			}
+		}
+	default:
 		res.Body.Close()
-		return nil, fmt.Errorf("Server error: Status %d while fetching image layer (%s)",
-			res.StatusCode, imgID)
+		return nil, utils.NewHTTPRequestError(fmt.Sprintf("Server error: Status %d while fetching image layer (%s)",
+			res.StatusCode, imgID), res)
 	}
 	return res.Body, nil
 }
@@ -478,6 +648,84 @@ func (r *Registry) PushRegistryTag(remote, revision, tag, registry string, token
 	return nil
 }
 
+// PushManifest uploads the signed manifest of remote:tag, and returns its
+// digest. ErrManifestsUnsupported is returned by the registries without
+// manifests.
+func (r *Registry) PushManifest(remote, tag string, m *Manifest, registry string, token []string) (string, error) {
+	path := fmt.Sprintf("repositories/%s/manifests/%s", remote, tag)
+
+	manifest, err := json.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+	digest, err := m.Digest()
+	if err != nil {
+		return "", err
+	}
+
+	req, err := r.reqFactory.NewRequest("PUT", registry+path, bytes.NewReader(manifest))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Add("Content-type", "application/json")
+	req.Header.Set("Authorization", "Token "+strings.Join(token, ","))
+	req.ContentLength = int64(len(manifest))
+	res, err := doWithCookies(r.client, req)
+	if err != nil {
+		return "", err
+	}
+	res.Body.Close()
+	if res.StatusCode == 404 || res.StatusCode == 405 {
+		return "", ErrManifestsUnsupported
+	}
+	if res.StatusCode != 200 && res.StatusCode != 201 {
+		return "", utils.NewHTTPRequestError(fmt.Sprintf("Internal server error: %d trying to push the manifest of %s:%s", res.StatusCode, remote, tag), res)
+	}
+	if remoteDigest := res.Header.Get("X-Docker-Content-Digest"); remoteDigest != "" && remoteDigest != digest {
+		return "", fmt.Errorf("Digest mismatch for the manifest of %s:%s: expected %s, got %s", remote, tag, digest, remoteDigest)
+	}
+	return digest, nil
+}
+
+// GetManifest fetches the manifest of remote for reference, a tag or a
+// digest, and returns it with its digest. Its signatures are verified, and
+// a manifest fetched by digest is verified against it.
+func (r *Registry) GetManifest(remote, reference string, registry string, token []string) (*Manifest, string, error) {
+	path := fmt.Sprintf("repositories/%s/manifests/%s", remote, reference)
+
+	req, err := r.reqFactory.NewRequest("GET", registry+path, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Authorization", "Token "+strings.Join(token, ","))
+	res, err := doWithCookies(r.client, req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == 404 {
+		return nil, "", fmt.Errorf("Manifest %s not found in repository %s", reference, remote)
+	}
+	if res.StatusCode != 200 {
+		return nil, "", utils.NewHTTPRequestError(fmt.Sprintf("HTTP code %d trying to fetch the manifest %s of %s", res.StatusCode, reference, remote), res)
+	}
+	manifest := &Manifest{}
+	if err := json.NewDecoder(res.Body).Decode(manifest); err != nil {
+		return nil, "", fmt.Errorf("Invalid manifest %s of %s: %s", reference, remote, err)
+	}
+	if _, err := manifest.Verify(); err != nil {
+		return nil, "", fmt.Errorf("Invalid manifest %s of %s: %s", reference, remote, err)
+	}
+	digest, err := manifest.Digest()
+	if err != nil {
+		return nil, "", err
+	}
+	if utils.IsDigest(reference) && digest != reference {
+		return nil, "", fmt.Errorf("Digest mismatch for the manifest %s of %s: got %s", reference, remote, digest)
+	}
+	return manifest, digest, nil
+}
+
 func (r *Registry) PushImageJSONIndex(indexEp, remote string, imgList []*ImgData, validate bool, regs []string) (*RepositoryData, error) {
 	cleanImgList := []*ImgData{}
 
@@ -650,16 +898,9 @@ type Registry struct {
 }
 
 func NewRegistry(root string, authConfig *auth.AuthConfig, factory *utils.HTTPRequestFactory) (r *Registry, err error) {
-	httpTransport := &http.Transport{
-		DisableKeepAlives: true,
-		Proxy:             http.ProxyFromEnvironment,
-	}
-
 	r = &Registry{
 		authConfig: authConfig,
-		client: &http.Client{
-			Transport: httpTransport,
-		},
+		client:     NewHTTPClient(),
 	}
 	r.client.Jar, err = cookiejar.New(nil)
 	if err != nil {
@@ -192,6 +192,12 @@ func handlerGetImage(w http.ResponseWriter, r *http.Request) {
 	writeHeaders(w)
 	layer_size := len(layer["layer"])
 	w.Header().Add("X-Docker-Size", strconv.Itoa(layer_size))
+	w.Header().Add("X-Docker-Checksum", layer["checksum_tarsum"])
+	if vars["action"] == "layer" {
+		// Honor the Range header, like a real registry
+		http.ServeContent(w, r, "layer", time.Time{}, strings.NewReader(layer["layer"]))
+		return
+	}
 	io.WriteString(w, layer[vars["action"]])
 }
 
@@ -1,8 +1,12 @@
 package registry
 
 import (
+	"bytes"
+	"fmt"
 	"github.com/dotcloud/docker/auth"
 	"github.com/dotcloud/docker/utils"
+	"io/ioutil"
+	"net/url"
 	"strings"
 	"testing"
 )
@@ -49,6 +53,16 @@ func TestLookupRemoteImage(t *testing.T) {
 	assertEqual(t, found, false, "Expected remote lookup to fail")
 }
 
+func TestLookupRemoteImages(t *testing.T) {
+	r := spawnTestRegistry(t)
+	exists := r.LookupRemoteImages([]string{IMAGE_ID, "abcdef"}, makeURL("/v1/"), TOKEN)
+	assertEqual(t, len(exists), 1, "Expected only one image to be found")
+	assertEqual(t, exists[IMAGE_ID], testLayers[IMAGE_ID]["checksum_tarsum"], "Expected the checksum of the image")
+	if _, found := exists["abcdef"]; found {
+		t.Fatal("Expected remote lookup to fail")
+	}
+}
+
 func TestGetRemoteImageJSON(t *testing.T) {
 	r := spawnTestRegistry(t)
 	json, size, err := r.GetRemoteImageJSON(IMAGE_ID, makeURL("/v1/"), TOKEN)
@@ -68,15 +82,32 @@ func TestGetRemoteImageJSON(t *testing.T) {
 
 func TestGetRemoteImageLayer(t *testing.T) {
 	r := spawnTestRegistry(t)
-	data, err := r.GetRemoteImageLayer(IMAGE_ID, makeURL("/v1/"), TOKEN)
+	data, err := r.GetRemoteImageLayer(IMAGE_ID, makeURL("/v1/"), TOKEN, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
 	if data == nil {
 		t.Fatal("Expected non-nil data result")
 	}
+	layer, err := ioutil.ReadAll(data)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	_, err = r.GetRemoteImageLayer("abcdef", makeURL("/v1/"), TOKEN)
+	// Resume the download after the 10th byte
+	data, err = r.GetRemoteImageLayer(IMAGE_ID, makeURL("/v1/"), TOKEN, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rest, err := ioutil.ReadAll(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rest, layer[10:]) {
+		t.Fatalf("Expected the layer to be resumed at byte 10, got %d bytes", len(rest))
+	}
+
+	_, err = r.GetRemoteImageLayer("abcdef", makeURL("/v1/"), TOKEN, 0)
 	if err == nil {
 		t.Fatal("Expected image not found error")
 	}
@@ -146,6 +177,20 @@ func TestResolveRepositoryName(t *testing.T) {
 	}
 	assertEqual(t, ep, "http://"+u+"/v1/", "Expected endpoint to be "+u)
 	assertEqual(t, repo, "private/moonbase", "Expected endpoint to be private/moonbase")
+
+	for _, name := range []string{"dir:/mnt/images/app", "dir:///mnt/images/app"} {
+		ep, repo, err = ResolveRepositoryName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, ep, "dir:///mnt/images/v1/", "Expected endpoint to be /mnt/images")
+		assertEqual(t, repo, "app", "Expected resolved repo to be app")
+	}
+	for _, name := range []string{"dir:mnt/images/app", "dir:/app"} {
+		if _, _, err := ResolveRepositoryName(name); err == nil {
+			t.Fatalf("Expected %s to be refused", name)
+		}
+	}
 }
 
 func TestPushRegistryTag(t *testing.T) {
@@ -206,3 +251,25 @@ func TestValidRepositoryName(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestMirrorHealth(t *testing.T) {
+	m := NewMirror(makeURL("/v1/"))
+	assertEqual(t, m.Status(), "Unchecked", "Expected an unchecked mirror")
+	assertEqual(t, m.Healthy(), true, "Expected the mirror to be healthy")
+
+	m.Fail(&utils.JSONError{Code: 404, Message: "HTTP code 404"})
+	assertEqual(t, m.Healthy(), true, "Expected a mirror without the image to stay healthy")
+	m.Fail(&utils.JSONError{Code: 502, Message: "HTTP code 502"})
+	assertEqual(t, m.Healthy(), false, "Expected a mirror answering 5xx to be skipped")
+
+	m = NewMirror(makeURL("/v1/"))
+	m.Fail(fmt.Errorf("Checksum mismatch for layer 42"))
+	assertEqual(t, m.Healthy(), true, "Expected a mirror serving a corrupted layer to stay healthy")
+	err := &url.Error{Op: "Get", URL: makeURL("/v1/"), Err: fmt.Errorf("connection reset")}
+	m.Fail(err)
+	assertEqual(t, m.Healthy(), false, "Expected the failed mirror to be skipped")
+	assertEqual(t, m.Status(), "Unhealthy: "+err.Error(), "")
+
+	m = NewMirror("http://127.0.0.1:1/v1/")
+	assertEqual(t, m.Healthy(), false, "Expected an unreachable mirror to be unhealthy")
+}
@@ -0,0 +1,206 @@
+package registry
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/dotcloud/docker/utils"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+)
+
+var (
+	securityLock       sync.RWMutex
+	enforceHTTPS       bool
+	insecureRegistries []string
+	httpMirrors        []string
+	certsDir           string
+)
+
+// SetSecurity makes the registry client refuse plain http and unverified
+// https, except for the insecure registries: hosts (with an optional port)
+// or CIDRs. The CA bundles (*.crt) to trust and the client certificate
+// (*.cert and its *.key) to present to a registry are read from the
+// directory <certs>/<host[:port]>. The mirrors configured with an http://
+// URL may be reached over plain http too, as they were explicitly asked
+// for, but their certificate is still verified over https.
+// Until it is called, any registry may be reached over plain http.
+func SetSecurity(insecure []string, certs string, mirrors []string) {
+	securityLock.Lock()
+	defer securityLock.Unlock()
+	enforceHTTPS = true
+	insecureRegistries = insecure
+	certsDir = certs
+	httpMirrors = nil
+	for _, mirror := range mirrors {
+		if u, err := url.Parse(mirror); err == nil && u.Scheme == "http" {
+			httpMirrors = append(httpMirrors, u.Host)
+		}
+	}
+}
+
+// isInsecure returns true if the registry hostport was explicitly allowed
+// to be reached over plain http.
+func isInsecure(hostport string) bool {
+	securityLock.RLock()
+	defer securityLock.RUnlock()
+	host := hostport
+	if h, _, err := net.SplitHostPort(hostport); err == nil {
+		host = h
+	}
+	var ips []net.IP
+	for _, entry := range insecureRegistries {
+		if entry == hostport || entry == host {
+			return true
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			continue
+		}
+		if ips == nil {
+			if ip := net.ParseIP(host); ip != nil {
+				ips = []net.IP{ip}
+			} else if ips, err = net.LookupIP(host); err != nil {
+				utils.Debugf("Could not resolve %s: %s", host, err)
+				ips = []net.IP{}
+			}
+		}
+		for _, ip := range ips {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// httpAllowed returns true if the registry hostport may be reached over
+// plain http.
+func httpAllowed(hostport string) bool {
+	securityLock.RLock()
+	enforce := enforceHTTPS
+	mirror := false
+	for _, m := range httpMirrors {
+		if m == hostport {
+			mirror = true
+		}
+	}
+	securityLock.RUnlock()
+	return !enforce || mirror || isInsecure(hostport)
+}
+
+// newTLSConfig returns the TLS configuration used to reach the registry
+// hostport.
+func newTLSConfig(hostport string) (*tls.Config, error) {
+	securityLock.RLock()
+	enforce, dir := enforceHTTPS, certsDir
+	securityLock.RUnlock()
+
+	tlsConfig := &tls.Config{
+		// The certificate of an insecure registry is not verified
+		InsecureSkipVerify: enforce && isInsecure(hostport),
+	}
+	if dir == "" {
+		return tlsConfig, nil
+	}
+	dir = path.Join(dir, hostport)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return tlsConfig, nil
+		}
+		return nil, err
+	}
+	for _, f := range files {
+		switch {
+		case strings.HasSuffix(f.Name(), ".crt"):
+			if tlsConfig.RootCAs == nil {
+				tlsConfig.RootCAs = x509.NewCertPool()
+			}
+			data, err := ioutil.ReadFile(path.Join(dir, f.Name()))
+			if err != nil {
+				return nil, err
+			}
+			if !tlsConfig.RootCAs.AppendCertsFromPEM(data) {
+				return nil, fmt.Errorf("No certificate found in %s", path.Join(dir, f.Name()))
+			}
+		case strings.HasSuffix(f.Name(), ".cert"):
+			keyName := strings.TrimSuffix(f.Name(), ".cert") + ".key"
+			cert, err := tls.LoadX509KeyPair(path.Join(dir, f.Name()), path.Join(dir, keyName))
+			if err != nil {
+				return nil, fmt.Errorf("Could not load the client certificate %s: %s", path.Join(dir, f.Name()), err)
+			}
+			tlsConfig.Certificates = append(tlsConfig.Certificates, cert)
+		}
+	}
+	return tlsConfig, nil
+}
+
+// checkScheme refuses the plain http requests to the registries which must
+// be reached over https.
+func checkScheme(req *http.Request) error {
+	if req.URL.Scheme == "http" && !httpAllowed(req.URL.Host) {
+		return fmt.Errorf("%s is not an insecure registry, refusing to reach it over http", req.URL.Host)
+	}
+	return nil
+}
+
+// registryTransport sends the requests to each registry host with its own
+// TLS configuration, and the requests to dir:// endpoints to a dirTransport.
+type registryTransport struct {
+	sync.Mutex
+	dir        *dirTransport
+	transports map[string]*http.Transport
+}
+
+func newRegistryTransport() *registryTransport {
+	return &registryTransport{
+		dir:        newDirTransport(),
+		transports: make(map[string]*http.Transport),
+	}
+}
+
+func (t *registryTransport) transport(hostport string) (*http.Transport, error) {
+	t.Lock()
+	defer t.Unlock()
+	if tr, exists := t.transports[hostport]; exists {
+		return tr, nil
+	}
+	tlsConfig, err := newTLSConfig(hostport)
+	if err != nil {
+		return nil, err
+	}
+	tr := &http.Transport{
+		DisableKeepAlives: true,
+		Proxy:             http.ProxyFromEnvironment,
+		TLSClientConfig:   tlsConfig,
+	}
+	t.transports[hostport] = tr
+	return tr, nil
+}
+
+func (t *registryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme == "dir" {
+		return t.dir.RoundTrip(req)
+	}
+	if err := checkScheme(req); err != nil {
+		return nil, err
+	}
+	tr, err := t.transport(req.URL.Host)
+	if err != nil {
+		return nil, err
+	}
+	return tr.RoundTrip(req)
+}
+
+// NewHTTPClient returns a client reaching the registries with their
+// security configuration.
+func NewHTTPClient() *http.Client {
+	return &http.Client{Transport: newRegistryTransport()}
+}
@@ -0,0 +1,136 @@
+package registry
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"testing"
+)
+
+func resetSecurity() {
+	securityLock.Lock()
+	defer securityLock.Unlock()
+	enforceHTTPS = false
+	insecureRegistries = nil
+	httpMirrors = nil
+	certsDir = ""
+}
+
+func pingHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Docker-Registry-Version", "0.6.0")
+	w.WriteHeader(http.StatusOK)
+}
+
+func TestInsecureRegistries(t *testing.T) {
+	SetSecurity([]string{"registry.local", "other.local:5000", "10.0.0.0/8"}, "", nil)
+	defer resetSecurity()
+
+	for _, hostport := range []string{"registry.local", "registry.local:5000", "other.local:5000", "10.1.2.3", "10.1.2.3:5000"} {
+		if !isInsecure(hostport) {
+			t.Fatalf("Expected %s to be insecure", hostport)
+		}
+	}
+	for _, hostport := range []string{"other.local", "other.local:443", "192.168.1.1:5000"} {
+		if isInsecure(hostport) || httpAllowed(hostport) {
+			t.Fatalf("Expected %s to require https", hostport)
+		}
+	}
+}
+
+func TestRefuseHTTP(t *testing.T) {
+	httpServer := httptest.NewServer(http.HandlerFunc(pingHandler))
+	defer httpServer.Close()
+	ep := httpServer.URL + "/v1/"
+
+	if err := pingRegistryEndpoint(ep); err != nil {
+		t.Fatalf("Expected http to be allowed until the security is set: %s", err)
+	}
+
+	SetSecurity(nil, "", nil)
+	defer resetSecurity()
+	if err := pingRegistryEndpoint(ep); err == nil {
+		t.Fatal("Expected http to be refused")
+	}
+	if _, err := NewHTTPClient().Get(ep + "_ping"); err == nil {
+		t.Fatal("Expected the client to refuse http")
+	}
+	u, _ := url.Parse(httpServer.URL)
+	if _, err := ExpandAndVerifyRegistryUrl(u.Host); err == nil {
+		t.Fatal("Expected no fallback to http")
+	}
+
+	SetSecurity(nil, "", []string{"https://" + u.Host + "/v1/"})
+	if err := pingRegistryEndpoint(ep); err == nil {
+		t.Fatal("Expected http to be refused to an https mirror")
+	}
+	SetSecurity(nil, "", []string{ep})
+	if err := pingRegistryEndpoint(ep); err != nil {
+		t.Fatalf("Expected http to be allowed to an http mirror: %s", err)
+	}
+	if isInsecure(u.Host) {
+		t.Fatal("Expected an http mirror to keep its certificate verified")
+	}
+
+	SetSecurity([]string{"127.0.0.0/8"}, "", nil)
+	if err := pingRegistryEndpoint(ep); err != nil {
+		t.Fatal(err)
+	}
+	endpoint, err := ExpandAndVerifyRegistryUrl(u.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, endpoint, ep, "Expected the fallback to http")
+}
+
+func TestRegistryCerts(t *testing.T) {
+	httpServer := httptest.NewTLSServer(http.HandlerFunc(pingHandler))
+	defer httpServer.Close()
+	ep := httpServer.URL + "/v1/"
+	u, _ := url.Parse(httpServer.URL)
+
+	certs, err := ioutil.TempDir("", "docker-test-registry-certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(certs)
+
+	SetSecurity(nil, certs, nil)
+	defer resetSecurity()
+	if err := pingRegistryEndpoint(ep); err == nil {
+		t.Fatal("Expected the unknown CA to be refused")
+	}
+
+	// An insecure registry is not verified
+	SetSecurity([]string{u.Host}, certs, nil)
+	if err := pingRegistryEndpoint(ep); err != nil {
+		t.Fatal(err)
+	}
+
+	SetSecurity(nil, certs, nil)
+	if err := os.MkdirAll(path.Join(certs, u.Host), 0700); err != nil {
+		t.Fatal(err)
+	}
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: httpServer.TLS.Certificates[0].Certificate[0]})
+	if err := ioutil.WriteFile(path.Join(certs, u.Host, "ca.crt"), ca, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := pingRegistryEndpoint(ep); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := NewHTTPClient().Get(ep + "_ping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if err := ioutil.WriteFile(path.Join(certs, u.Host, "client.cert"), ca, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := pingRegistryEndpoint(ep); err == nil {
+		t.Fatal("Expected a client certificate without its key to be an error")
+	}
+}
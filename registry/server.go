@@ -0,0 +1,701 @@
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dotcloud/docker/archive"
+	"github.com/dotcloud/docker/utils"
+	"github.com/gorilla/mux"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	errImageExists = errors.New("Image already exists")
+	validTagName   = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+)
+
+type serverFunc func(s *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error
+
+// Server is a minimal registry, serving the v1 API spoken by Registry from
+// a directory. It also answers the calls made to the index, without any
+// authentication, so that it can be used on its own:
+//
+//	images/<id>/{json,layer,checksum}
+//	repositories/<namespace>/<name>/{_index_images,tag_<tag>}
+//	repositories/<namespace>/<name>/{manifest_<digest>,manifesttag_<tag>}
+type Server struct {
+	sync.Mutex
+	root   string
+	router *mux.Router
+}
+
+func NewServer(root string) (*Server, error) {
+	for _, dir := range []string{"images", "repositories"} {
+		if err := os.MkdirAll(path.Join(root, dir), 0700); err != nil {
+			return nil, err
+		}
+	}
+	s := &Server{root: root, router: mux.NewRouter()}
+
+	m := map[string]map[string]serverFunc{
+		"GET": {
+			"/v1/_ping":                                              getPing,
+			"/v1/images/{id:[a-f0-9]+}/json":                         getImageJSON,
+			"/v1/images/{id:[a-f0-9]+}/layer":                        getImageLayer,
+			"/v1/images/{id:[a-f0-9]+}/ancestry":                     getImageAncestry,
+			"/v1/repositories/{repository:.+}/tags":                  getRepositoryTags,
+			"/v1/repositories/{repository:.+}/tags/{tag}":            getRepositoryTag,
+			"/v1/repositories/{repository:.+}/images":                getRepositoryImages,
+			"/v1/repositories/{repository:.+}/manifests/{reference}": getRepositoryManifest,
+			"/v1/search": getSearch,
+		},
+		"PUT": {
+			"/v1/images/{id:[a-f0-9]+}/json":                   putImageJSON,
+			"/v1/images/{id:[a-f0-9]+}/layer":                  putImageLayer,
+			"/v1/images/{id:[a-f0-9]+}/checksum":               putImageChecksum,
+			"/v1/repositories/{repository:.+}/tags/{tag}":      putRepositoryTag,
+			"/v1/repositories/{repository:.+}/images":          putRepositoryImages,
+			"/v1/repositories/{repository:.+}/manifests/{tag}": putRepositoryManifest,
+			"/v1/repositories/{repository:.+}/":                putRepository,
+		},
+		"DELETE": {
+			"/v1/repositories/{repository:.+}/tags/{tag}": deleteRepositoryTag,
+		},
+	}
+	for method, routes := range m {
+		for route, fct := range routes {
+			localFct := fct
+			s.router.Path(route).Methods(method).HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				utils.Debugf("[registry] %s %s", r.Method, r.URL)
+				w.Header().Set("X-Docker-Registry-Version", "0.6.0")
+				w.Header().Set("X-Docker-Registry-Standalone", "true")
+				if err := localFct(s, w, r, mux.Vars(r)); err != nil {
+					serverError(w, err)
+				}
+			})
+		}
+	}
+	return s, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.router.ServeHTTP(w, r)
+}
+
+func serverError(w http.ResponseWriter, err error) {
+	statusCode := http.StatusInternalServerError
+	errStr := err.Error()
+	if os.IsNotExist(err) || strings.Contains(errStr, "not found") {
+		statusCode = http.StatusNotFound
+	} else if err == errImageExists {
+		statusCode = http.StatusConflict
+	} else if strings.HasPrefix(errStr, "Bad parameter") || strings.HasPrefix(errStr, "Checksum mismatch") {
+		statusCode = http.StatusBadRequest
+	}
+	utils.Errorf("[registry] %s", errStr)
+	serveJSON(w, statusCode, map[string]string{"error": errStr})
+}
+
+func serveJSON(w http.ResponseWriter, statusCode int, v interface{}) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	return json.NewEncoder(w).Encode(v)
+}
+
+// serveIndex sets the headers the index answers with: the registry to use
+// is this one, and any token is accepted.
+func serveIndex(w http.ResponseWriter, r *http.Request, repository, access string) {
+	w.Header().Set("X-Docker-Endpoints", r.Host)
+	w.Header().Set("X-Docker-Token", fmt.Sprintf("signature=unsigned,repository=\"%s\",access=%s", repository, access))
+}
+
+func (s *Server) imagePath(id string, elem ...string) string {
+	return path.Join(append([]string{s.root, "images", id}, elem...)...)
+}
+
+// repositoryPath returns the directory of a repository. Like the client,
+// names without a namespace belong to "library".
+func (s *Server) repositoryPath(name string) (string, error) {
+	if !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+	if err := validateRepositoryName(name); err != nil {
+		return "", fmt.Errorf("Bad parameter: %s", err)
+	}
+	if base := path.Base(name); base == "." || base == ".." {
+		return "", fmt.Errorf("Bad parameter: invalid repository name %s", name)
+	}
+	return path.Join(s.root, "repositories", name), nil
+}
+
+// checkImage returns an error if the image has not been completely uploaded.
+func (s *Server) checkImage(id string) error {
+	if _, err := os.Stat(s.imagePath(id, "checksum")); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("Image %s not found", id)
+		}
+		return err
+	}
+	return nil
+}
+
+func getPing(s *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	return serveJSON(w, http.StatusOK, true)
+}
+
+func getImageJSON(s *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	id := vars["id"]
+	if err := s.checkImage(id); err != nil {
+		return err
+	}
+	jsonRaw, err := ioutil.ReadFile(s.imagePath(id, "json"))
+	if err != nil {
+		return err
+	}
+	layer, err := os.Stat(s.imagePath(id, "layer"))
+	if err != nil {
+		return err
+	}
+	checksum, err := ioutil.ReadFile(s.imagePath(id, "checksum"))
+	if err != nil {
+		return err
+	}
+	w.Header().Set("X-Docker-Size", strconv.FormatInt(layer.Size(), 10))
+	w.Header().Set("X-Docker-Checksum", string(checksum))
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(jsonRaw)
+	return err
+}
+
+func getImageLayer(s *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	id := vars["id"]
+	if err := s.checkImage(id); err != nil {
+		return err
+	}
+	f, err := os.Open(s.imagePath(id, "layer"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// Honor the Range header, so that interrupted pulls can resume
+	http.ServeContent(w, r, "layer", time.Time{}, f)
+	return nil
+}
+
+func getImageAncestry(s *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	var ancestry []string
+	for id := vars["id"]; id != ""; {
+		if err := s.checkImage(id); err != nil {
+			return err
+		}
+		jsonRaw, err := ioutil.ReadFile(s.imagePath(id, "json"))
+		if err != nil {
+			return err
+		}
+		var img struct {
+			Parent string `json:"parent"`
+		}
+		if err := json.Unmarshal(jsonRaw, &img); err != nil {
+			return err
+		}
+		ancestry = append(ancestry, id)
+		id = img.Parent
+	}
+	return serveJSON(w, http.StatusOK, ancestry)
+}
+
+func putImageJSON(s *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	id := vars["id"]
+	if s.checkImage(id) == nil {
+		return errImageExists
+	}
+	jsonRaw, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	var img struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(jsonRaw, &img); err != nil {
+		return fmt.Errorf("Bad parameter: %s", err)
+	}
+	if img.ID != id {
+		return fmt.Errorf("Bad parameter: the json is the one of %s", img.ID)
+	}
+	if err := os.MkdirAll(s.imagePath(id), 0700); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(s.imagePath(id, "json"), jsonRaw, 0600); err != nil {
+		return err
+	}
+	return serveJSON(w, http.StatusOK, true)
+}
+
+func putImageLayer(s *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	id := vars["id"]
+	if s.checkImage(id) == nil {
+		return errImageExists
+	}
+	jsonRaw, err := ioutil.ReadFile(s.imagePath(id, "json"))
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(s.imagePath(id), "layer-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	// Compute the checksum the client is about to send while storing the layer
+	body := io.TeeReader(r.Body, f)
+	layer, err := archive.DecompressStream(body)
+	if err != nil {
+		return err
+	}
+	tarsum := &utils.TarSum{Reader: layer}
+	if _, err := io.Copy(ioutil.Discard, tarsum); err != nil {
+		return fmt.Errorf("Bad parameter: %s", err)
+	}
+	if _, err := io.Copy(ioutil.Discard, body); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(s.imagePath(id, "_checksum"), []byte(tarsum.Sum(jsonRaw)), 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), s.imagePath(id, "layer")); err != nil {
+		return err
+	}
+	return serveJSON(w, http.StatusOK, true)
+}
+
+func putImageChecksum(s *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	id := vars["id"]
+	computed, err := ioutil.ReadFile(s.imagePath(id, "_checksum"))
+	if err != nil {
+		return err
+	}
+	if checksum := r.Header.Get("X-Docker-Checksum"); checksum != string(computed) {
+		return fmt.Errorf("Checksum mismatch for %s: got %s, expected %s", id, checksum, computed)
+	}
+	if err := os.Rename(s.imagePath(id, "_checksum"), s.imagePath(id, "checksum")); err != nil {
+		return err
+	}
+	return serveJSON(w, http.StatusOK, true)
+}
+
+func getRepositoryTags(s *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	root, err := s.repositoryPath(vars["repository"])
+	if err != nil {
+		return err
+	}
+	files, err := ioutil.ReadDir(root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("Repository not found")
+		}
+		return err
+	}
+	tags := make(map[string]string)
+	for _, f := range files {
+		if !strings.HasPrefix(f.Name(), "tag_") {
+			continue
+		}
+		id, err := ioutil.ReadFile(path.Join(root, f.Name()))
+		if err != nil {
+			return err
+		}
+		tags[strings.TrimPrefix(f.Name(), "tag_")] = string(id)
+	}
+	return serveJSON(w, http.StatusOK, tags)
+}
+
+func getRepositoryTag(s *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	root, err := s.repositoryPath(vars["repository"])
+	if err != nil {
+		return err
+	}
+	if !validTagName.MatchString(vars["tag"]) {
+		return fmt.Errorf("Bad parameter: invalid tag name %s", vars["tag"])
+	}
+	id, err := ioutil.ReadFile(path.Join(root, "tag_"+vars["tag"]))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("Tag not found")
+		}
+		return err
+	}
+	return serveJSON(w, http.StatusOK, string(id))
+}
+
+func putRepositoryTag(s *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	root, err := s.repositoryPath(vars["repository"])
+	if err != nil {
+		return err
+	}
+	if !validTagName.MatchString(vars["tag"]) {
+		return fmt.Errorf("Bad parameter: invalid tag name %s", vars["tag"])
+	}
+	var id string
+	if err := json.NewDecoder(r.Body).Decode(&id); err != nil {
+		return fmt.Errorf("Bad parameter: %s", err)
+	}
+	if err := s.checkImage(id); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path.Join(root, "tag_"+vars["tag"]), []byte(id), 0600); err != nil {
+		return err
+	}
+	return serveJSON(w, http.StatusOK, true)
+}
+
+func deleteRepositoryTag(s *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	root, err := s.repositoryPath(vars["repository"])
+	if err != nil {
+		return err
+	}
+	if !validTagName.MatchString(vars["tag"]) {
+		return fmt.Errorf("Bad parameter: invalid tag name %s", vars["tag"])
+	}
+	s.Lock()
+	defer s.Unlock()
+	if err := os.Remove(path.Join(root, "tag_"+vars["tag"])); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("Tag not found")
+		}
+		return err
+	}
+	return serveJSON(w, http.StatusOK, true)
+}
+
+func getRepositoryManifest(s *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	root, err := s.repositoryPath(vars["repository"])
+	if err != nil {
+		return err
+	}
+	digest := vars["reference"]
+	if !utils.IsDigest(digest) {
+		if !validTagName.MatchString(digest) {
+			return fmt.Errorf("Bad parameter: invalid tag name %s", digest)
+		}
+		tagDigest, err := ioutil.ReadFile(path.Join(root, "manifesttag_"+digest))
+		if err != nil {
+			if os.IsNotExist(err) {
+				return fmt.Errorf("Manifest not found")
+			}
+			return err
+		}
+		digest = string(tagDigest)
+	}
+	manifest, err := ioutil.ReadFile(path.Join(root, "manifest_"+digest))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("Manifest not found")
+		}
+		return err
+	}
+	w.Header().Set("X-Docker-Content-Digest", digest)
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(manifest)
+	return err
+}
+
+// putRepositoryManifest stores the manifest of a tag, once its layers have
+// been uploaded with the checksums it lists.
+func putRepositoryManifest(s *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	root, err := s.repositoryPath(vars["repository"])
+	if err != nil {
+		return err
+	}
+	tag := vars["tag"]
+	if !validTagName.MatchString(tag) {
+		return fmt.Errorf("Bad parameter: invalid tag name %s", tag)
+	}
+	manifest, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	var m Manifest
+	if err := json.Unmarshal(manifest, &m); err != nil {
+		return fmt.Errorf("Bad parameter: %s", err)
+	}
+	if m.Tag != tag || len(m.Layers) == 0 {
+		return fmt.Errorf("Bad parameter: invalid manifest for tag %s", tag)
+	}
+	if _, err := m.Verify(); err != nil {
+		return fmt.Errorf("Bad parameter: %s", err)
+	}
+	for _, layer := range m.Layers {
+		checksum, err := ioutil.ReadFile(s.imagePath(layer.ID, "checksum"))
+		if err != nil {
+			if os.IsNotExist(err) {
+				return fmt.Errorf("Image %s not found", layer.ID)
+			}
+			return err
+		}
+		if layer.Checksum != string(checksum) {
+			return fmt.Errorf("Checksum mismatch for %s: got %s, expected %s", layer.ID, layer.Checksum, checksum)
+		}
+	}
+	digest, err := m.Digest()
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	if id, err := ioutil.ReadFile(path.Join(root, "tag_"+tag)); err == nil && string(id) != m.Layers[len(m.Layers)-1].ID {
+		return fmt.Errorf("Bad parameter: tag %s points to %s", tag, id)
+	}
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path.Join(root, "manifest_"+digest), manifest, 0600); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path.Join(root, "manifesttag_"+tag), []byte(digest), 0600); err != nil {
+		return err
+	}
+	w.Header().Set("X-Docker-Content-Digest", digest)
+	return serveJSON(w, http.StatusOK, true)
+}
+
+// readIndexImages returns the images of the repository kept for the index
+// calls.
+func readIndexImages(root string) ([]*ImgData, error) {
+	var imgList []*ImgData
+	jsonRaw, err := ioutil.ReadFile(path.Join(root, "_index_images"))
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(jsonRaw, &imgList); err != nil {
+		return nil, err
+	}
+	return imgList, nil
+}
+
+// updateIndexImages merges imgList into the images of the repository kept
+// for the index calls.
+func (s *Server) updateIndexImages(root string, imgList []*ImgData) error {
+	s.Lock()
+	defer s.Unlock()
+	current, err := readIndexImages(root)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	byID := make(map[string]*ImgData)
+	for _, img := range current {
+		byID[img.ID] = img
+	}
+	for _, img := range imgList {
+		if known, exists := byID[img.ID]; exists {
+			if img.Checksum != "" {
+				known.Checksum = img.Checksum
+			}
+			continue
+		}
+		byID[img.ID] = img
+		current = append(current, img)
+	}
+	jsonRaw, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path.Join(root, "_index_images"), jsonRaw, 0600)
+}
+
+func getRepositoryImages(s *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	root, err := s.repositoryPath(vars["repository"])
+	if err != nil {
+		return err
+	}
+	s.Lock()
+	imgList, err := readIndexImages(root)
+	s.Unlock()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("Repository not found")
+		}
+		return err
+	}
+	for _, img := range imgList {
+		if img.Checksum == "" {
+			if checksum, err := ioutil.ReadFile(s.imagePath(img.ID, "checksum")); err == nil {
+				img.Checksum = string(checksum)
+			}
+		}
+	}
+	serveIndex(w, r, vars["repository"], "read")
+	return serveJSON(w, http.StatusOK, imgList)
+}
+
+func putRepository(s *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	root, err := s.repositoryPath(vars["repository"])
+	if err != nil {
+		return err
+	}
+	var imgList []*ImgData
+	if err := json.NewDecoder(r.Body).Decode(&imgList); err != nil {
+		return fmt.Errorf("Bad parameter: %s", err)
+	}
+	if err := s.updateIndexImages(root, imgList); err != nil {
+		return err
+	}
+	serveIndex(w, r, vars["repository"], "write")
+	return serveJSON(w, http.StatusOK, "")
+}
+
+func putRepositoryImages(s *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	root, err := s.repositoryPath(vars["repository"])
+	if err != nil {
+		return err
+	}
+	var imgList []*ImgData
+	if err := json.NewDecoder(r.Body).Decode(&imgList); err != nil {
+		return fmt.Errorf("Bad parameter: %s", err)
+	}
+	if err := s.updateIndexImages(root, imgList); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func getSearch(s *Server, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	term := r.FormValue("q")
+	results := &SearchResults{Query: term, Results: []SearchResult{}}
+	namespaces, err := ioutil.ReadDir(path.Join(s.root, "repositories"))
+	if err != nil {
+		return err
+	}
+	for _, namespace := range namespaces {
+		repos, err := ioutil.ReadDir(path.Join(s.root, "repositories", namespace.Name()))
+		if err != nil {
+			return err
+		}
+		for _, repo := range repos {
+			name := namespace.Name() + "/" + repo.Name()
+			if strings.Contains(name, term) {
+				results.Results = append(results.Results, SearchResult{Name: name})
+			}
+		}
+	}
+	results.NumResults = len(results.Results)
+	return serveJSON(w, http.StatusOK, results)
+}
+
+// dirTransport serves the requests made to dir:// endpoints in process, with
+// a Server rooted in the directory of the registry.
+type dirTransport struct {
+	sync.Mutex
+	servers map[string]*Server
+}
+
+func newDirTransport() *dirTransport {
+	return &dirTransport{servers: make(map[string]*Server)}
+}
+
+func (t *dirTransport) server(root string) (*Server, error) {
+	t.Lock()
+	defer t.Unlock()
+	if s, exists := t.servers[root]; exists {
+		return s, nil
+	}
+	s, err := NewServer(root)
+	if err != nil {
+		return nil, err
+	}
+	t.servers[root] = s
+	return s, nil
+}
+
+func (t *dirTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	i := strings.Index(req.URL.Path, "/v1/")
+	if i < 0 {
+		return nil, fmt.Errorf("Invalid registry directory URL: %s", req.URL)
+	}
+	root := req.URL.Path[:i]
+	s, err := t.server(root)
+	if err != nil {
+		return nil, err
+	}
+
+	r := new(http.Request)
+	*r = *req
+	r.URL = &url.URL{Path: req.URL.Path[i:], RawQuery: req.URL.RawQuery}
+	// The index answers with its host as the registry endpoint
+	r.Host = root
+	if r.Body == nil {
+		r.Body = ioutil.NopCloser(strings.NewReader(""))
+	}
+
+	pr, pw := io.Pipe()
+	w := &pipeResponseWriter{header: make(http.Header), pipe: pw, ready: make(chan struct{})}
+	go func() {
+		s.ServeHTTP(w, r)
+		r.Body.Close()
+		w.WriteHeader(http.StatusOK)
+		pw.Close()
+	}()
+	<-w.ready
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", w.status, http.StatusText(w.status)),
+		StatusCode:    w.status,
+		Proto:         "HTTP/1.0",
+		ProtoMajor:    1,
+		Header:        w.sent,
+		Body:          pr,
+		ContentLength: -1,
+		Request:       req,
+	}, nil
+}
+
+// pipeResponseWriter streams the body of a response through a pipe, once
+// its status and headers are sent.
+type pipeResponseWriter struct {
+	header http.Header
+	sent   http.Header
+	status int
+	pipe   *io.PipeWriter
+	ready  chan struct{}
+	once   sync.Once
+}
+
+func (w *pipeResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *pipeResponseWriter) WriteHeader(status int) {
+	w.once.Do(func() {
+		w.status = status
+		w.sent = make(http.Header)
+		for k, v := range w.header {
+			w.sent[k] = append([]string(nil), v...)
+		}
+		close(w.ready)
+	})
+}
+
+func (w *pipeResponseWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.pipe.Write(b)
+}
@@ -0,0 +1,245 @@
+package registry
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"github.com/dotcloud/docker/utils"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+)
+
+const (
+	serverTestParent = "1111111111111111111111111111111111111111111111111111111111111111"
+	serverTestChild  = "2222222222222222222222222222222222222222222222222222222222222222"
+)
+
+func testServerLayer(t *testing.T, name string) []byte {
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	content := []byte("content of " + name)
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func testServerPushImage(t *testing.T, r *Registry, ep, id, parent string) *ImgData {
+	jsonRaw := []byte(`{"id":"` + id + `","parent":"` + parent + `"}`)
+	imgData := &ImgData{ID: id}
+	if err := r.PushImageJSONRegistry(imgData, jsonRaw, ep, nil); err != nil {
+		t.Fatal(err)
+	}
+	checksum, err := r.PushImageLayerRegistry(id, bytes.NewReader(testServerLayer(t, id)), ep, nil, jsonRaw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imgData.Checksum = checksum
+	if err := r.PushImageChecksumRegistry(imgData, ep, nil); err != nil {
+		t.Fatal(err)
+	}
+	return imgData
+}
+
+// testServerPushPull pushes two images to the repository remote of the
+// registry ep, and pulls them back.
+func testServerPushPull(t *testing.T, ep, remote string) {
+	r := spawnTestRegistry(t)
+	imgList := []*ImgData{{ID: serverTestParent}, {ID: serverTestChild, Tag: "latest"}}
+	repoData, err := r.PushImageJSONIndex(ep, remote, imgList, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(repoData.Endpoints), 1, "Expected one endpoint")
+	assertEqual(t, repoData.Endpoints[0], ep, "Expected the server to be its own registry")
+
+	parent := testServerPushImage(t, r, ep, serverTestParent, "")
+	child := testServerPushImage(t, r, ep, serverTestChild, serverTestParent)
+	if err := r.PushImageJSONRegistry(child, []byte(`{"id":"`+serverTestChild+`"}`), ep, nil); err != ErrAlreadyExists {
+		t.Fatalf("Expected %s, got %v", ErrAlreadyExists, err)
+	}
+	if err := r.PushRegistryTag(remote, serverTestChild, "latest", ep, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.PushImageJSONIndex(ep, remote, []*ImgData{parent, child}, true, repoData.Endpoints); err != nil {
+		t.Fatal(err)
+	}
+	testServerManifest(t, r, ep, remote, parent, child)
+
+	repoData, err = r.GetRepositoryData(ep, remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(repoData.ImgList), 2, "Expected 2 images in ImgList")
+	assertEqual(t, repoData.ImgList[serverTestChild].Checksum, child.Checksum, "Expected the pushed checksum")
+
+	tags, err := r.GetRemoteTags(repoData.Endpoints, remote, repoData.Tokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, tags["latest"], serverTestChild, "Expected tag latest to map to "+serverTestChild)
+
+	history, err := r.GetRemoteHistory(serverTestChild, ep, repoData.Tokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(history), 2, "Expected 2 images in history")
+	assertEqual(t, history[1], serverTestParent, "Expected the parent as second ancestry")
+
+	if _, _, err := r.GetRemoteImageJSON(serverTestChild, ep, repoData.Tokens); err != nil {
+		t.Fatal(err)
+	}
+	layer, err := r.GetRemoteImageLayer(serverTestChild, ep, repoData.Tokens, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer layer.Close()
+	if _, err := ioutil.ReadAll(layer); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.GetRemoteTags(repoData.Endpoints, "foo42/baz", repoData.Tokens); err == nil {
+		t.Fatal("Expected error when fetching tags for bogus repo")
+	}
+}
+
+func testServerManifest(t *testing.T, r *Registry, ep, remote string, parent, child *ImgData) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wrong := &Manifest{
+		SchemaVersion: 1,
+		Name:          remote,
+		Tag:           "latest",
+		Layers:        []*ManifestLayer{{ID: parent.ID, Checksum: child.Checksum}, {ID: child.ID, Checksum: child.Checksum}},
+	}
+	if err := wrong.Sign(key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.PushManifest(remote, "latest", wrong, ep, nil); err == nil {
+		t.Fatal("Expected a manifest with a wrong checksum to be refused")
+	}
+
+	manifest := &Manifest{
+		SchemaVersion: 1,
+		Name:          remote,
+		Tag:           "latest",
+		Layers:        []*ManifestLayer{{ID: parent.ID, Checksum: parent.Checksum}, {ID: child.ID, Checksum: child.Checksum}},
+	}
+	if _, err := r.PushManifest(remote, "latest", manifest, ep, nil); err == nil {
+		t.Fatal("Expected an unsigned manifest to be refused")
+	}
+	if err := manifest.Sign(key); err != nil {
+		t.Fatal(err)
+	}
+	digest, err := r.PushManifest(remote, "latest", manifest, ep, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := manifest.Payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, digest, utils.Digest(payload), "Expected the digest of the payload of the manifest")
+
+	for _, reference := range []string{"latest", digest} {
+		fetched, fetchedDigest, err := r.GetManifest(remote, reference, ep, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, len(fetched.Signatures), 1, "Expected the signature of the manifest")
+		assertEqual(t, fetched.Layers[1].Checksum, child.Checksum, "Expected the pushed manifest")
+		assertEqual(t, fetchedDigest, digest, "Expected the digest of the manifest")
+	}
+	if _, _, err := r.GetManifest(remote, utils.Digest([]byte("foo")), ep, nil); err == nil {
+		t.Fatal("Expected error when fetching an unknown digest")
+	}
+}
+
+func TestServerPushPull(t *testing.T) {
+	root, err := ioutil.TempDir("", "docker-test-registry-server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	s, err := NewServer(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpServer := httptest.NewServer(s)
+	defer httpServer.Close()
+	ep := httpServer.URL + "/v1/"
+
+	if err := pingRegistryEndpoint(ep); err != nil {
+		t.Fatal(err)
+	}
+	testServerPushPull(t, ep, REPO)
+}
+
+func TestDirPushPull(t *testing.T) {
+	root, err := ioutil.TempDir("", "docker-test-registry-dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	ep, remote, err := ResolveRepositoryName("dir:" + root + "/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, ep, "dir://"+root+"/v1/", "Expected the directory as endpoint")
+	assertEqual(t, remote, "bar", "Expected the last element as repository")
+	testServerPushPull(t, ep, remote)
+
+	if _, err := os.Stat(path.Join(root, "images", serverTestChild, "layer")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path.Join(root, "repositories", "library", "bar", "tag_latest")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerWrongChecksum(t *testing.T) {
+	root, err := ioutil.TempDir("", "docker-test-registry-server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	s, err := NewServer(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpServer := httptest.NewServer(s)
+	defer httpServer.Close()
+	ep := httpServer.URL + "/v1/"
+
+	r := spawnTestRegistry(t)
+	jsonRaw := []byte(`{"id":"` + serverTestParent + `"}`)
+	imgData := &ImgData{ID: serverTestParent}
+	if err := r.PushImageJSONRegistry(imgData, jsonRaw, ep, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.PushImageLayerRegistry(serverTestParent, bytes.NewReader(testServerLayer(t, "foo")), ep, nil, jsonRaw); err != nil {
+		t.Fatal(err)
+	}
+	imgData.Checksum = "tarsum+sha256:0000000000000000000000000000000000000000000000000000000000000000"
+	if err := r.PushImageChecksumRegistry(imgData, ep, nil); err == nil {
+		t.Fatal("Expected the wrong checksum to be refused")
+	}
+	if r.LookupRemoteImage(serverTestParent, ep, nil) {
+		t.Fatal("Expected the image with a wrong checksum not to be available")
+	}
+}
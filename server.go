@@ -34,6 +34,7 @@ func (srv *Server) Close() error {
 
 func init() {
 	engine.Register("initapi", jobInitApi)
+	engine.Register("serveregistry", jobServeRegistry)
 }
 
 // jobInitApi runs the remote api server `srv` as a daemon,
@@ -78,6 +79,10 @@ func jobInitApi(job *engine.Job) engine.Status {
 		job.Error(err)
 		return engine.StatusErr
 	}
+	if err := job.Eng.Register("squash", srv.ImageSquash); err != nil {
+		job.Error(err)
+		return engine.StatusErr
+	}
 	if err := job.Eng.Register("serveapi", srv.ListenAndServe); err != nil {
 		job.Error(err)
 		return engine.StatusErr
@@ -85,6 +90,26 @@ func jobInitApi(job *engine.Job) engine.Status {
 	return engine.StatusOK
 }
 
+// jobServeRegistry serves a minimal registry on the tcp address given as
+// argument, storing the images in the directory given by Root.
+func jobServeRegistry(job *engine.Job) engine.Status {
+	if len(job.Args) != 1 {
+		job.Errorf("Usage: %s ADDR", job.Name)
+		return engine.StatusErr
+	}
+	reg, err := registry.NewServer(job.Getenv("Root"))
+	if err != nil {
+		job.Error(err)
+		return engine.StatusErr
+	}
+	job.Logf("Serving the registry in %s on %s", job.Getenv("Root"), job.Args[0])
+	if err := http.ListenAndServe(job.Args[0], reg); err != nil {
+		job.Error(err)
+		return engine.StatusErr
+	}
+	return engine.StatusOK
+}
+
 func (srv *Server) ListenAndServe(job *engine.Job) engine.Status {
 	protoAddrs := job.Args
 	chErrors := make(chan error, len(protoAddrs))
@@ -208,12 +233,12 @@ func (srv *Server) ContainerExport(name string, out io.Writer) error {
 	return fmt.Errorf("No such container: %s", name)
 }
 
-// ImageExport exports all images with the given tag. All versions
-// containing the same tag are exported. The resulting output is an
-// uncompressed tar ball.
-// name is the set of tags to export.
+// ImageExport exports the given images to a single uncompressed tar ball.
+// Each name is either a repository, whose tags are all exported, a
+// repository:tag or an image ID. The layers shared by several images are
+// only written once, and the tags are merged into one `repositories` file.
 // out is the writer where the images are written to.
-func (srv *Server) ImageExport(name string, out io.Writer) error {
+func (srv *Server) ImageExport(names []string, out io.Writer) error {
 	// get image json
 	tempdir, err := ioutil.TempDir("", "docker-export-")
 	if err != nil {
@@ -221,33 +246,35 @@ func (srv *Server) ImageExport(name string, out io.Writer) error {
 	}
 	defer os.RemoveAll(tempdir)
 
-	utils.Debugf("Serializing %s", name)
-
-	rootRepo, err := srv.runtime.repositories.Get(name)
-	if err != nil {
-		return err
-	}
-	if rootRepo != nil {
-		for _, id := range rootRepo {
-			image, err := srv.ImageInspect(id)
-			if err != nil {
-				return err
-			}
-
-			if err := srv.exportImage(image, tempdir); err != nil {
-				return err
-			}
+	repositories := map[string]Repository{}
+	addTag := func(repoName, tag, id string) {
+		if _, exists := repositories[repoName]; !exists {
+			repositories[repoName] = Repository{}
 		}
+		repositories[repoName][tag] = id
+	}
 
-		// write repositories
-		rootRepoMap := map[string]Repository{}
-		rootRepoMap[name] = rootRepo
-		rootRepoJson, _ := json.Marshal(rootRepoMap)
+	for _, name := range names {
+		utils.Debugf("Serializing %s", name)
 
-		if err := ioutil.WriteFile(path.Join(tempdir, "repositories"), rootRepoJson, os.ModeAppend); err != nil {
+		rootRepo, err := srv.runtime.repositories.Get(name)
+		if err != nil {
 			return err
 		}
-	} else {
+		if rootRepo != nil {
+			for tag, id := range rootRepo {
+				image, err := srv.ImageInspect(id)
+				if err != nil {
+					return err
+				}
+				if err := srv.exportImage(image, tempdir); err != nil {
+					return err
+				}
+				addTag(name, tag, image.ID)
+			}
+			continue
+		}
+
 		image, err := srv.ImageInspect(name)
 		if err != nil {
 			return err
@@ -255,6 +282,23 @@ func (srv *Server) ImageExport(name string, out io.Writer) error {
 		if err := srv.exportImage(image, tempdir); err != nil {
 			return err
 		}
+		// Only keep the tag if the image was referred to by repository:tag
+		if repoName, tag := utils.ParseRepositoryTag(name); tag != "" {
+			if repo, err := srv.runtime.repositories.Get(repoName); err == nil && repo != nil && repo[tag] == image.ID {
+				addTag(repoName, tag, image.ID)
+			}
+		}
+	}
+
+	if len(repositories) > 0 {
+		// write repositories
+		repositoriesJson, err := json.Marshal(repositories)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path.Join(tempdir, "repositories"), repositoriesJson, os.ModeAppend); err != nil {
+			return err
+		}
 	}
 
 	fs, err := archive.Tar(tempdir, archive.Uncompressed)
@@ -272,6 +316,11 @@ func (srv *Server) exportImage(image *Image, tempdir string) error {
 	for i := image; i != nil; {
 		// temporary directory
 		tmpImageDir := path.Join(tempdir, i.ID)
+		if _, err := os.Stat(tmpImageDir); err == nil {
+			// This layer, and thus all its parents, were already
+			// exported with another image
+			return nil
+		}
 		if err := os.Mkdir(tmpImageDir, os.ModeDir); err != nil {
 			return err
 		}
@@ -283,14 +332,19 @@ func (srv *Server) exportImage(image *Image, tempdir string) error {
 			return err
 		}
 
-		// serialize json
-		b, err := json.Marshal(i)
+		// copy the json as stored, which the checksum covers
+		b, err := srv.imageJSON(i.ID)
 		if err != nil {
 			return err
 		}
 		if err := ioutil.WriteFile(path.Join(tmpImageDir, "json"), b, os.ModeAppend); err != nil {
 			return err
 		}
+		if i.Checksum != "" {
+			if err := ioutil.WriteFile(path.Join(tmpImageDir, "checksum"), []byte(i.Checksum), os.ModeAppend); err != nil {
+				return err
+			}
+		}
 
 		// serialize filesystem
 		fs, err := i.TarLayer()
@@ -322,7 +376,9 @@ func (srv *Server) exportImage(image *Image, tempdir string) error {
 
 // Loads a set of images into the repository. This is the complementary of ImageExport.
 // The input stream is an uncompressed tar ball containing images and metadata.
-func (srv *Server) ImageLoad(in io.Reader) error {
+// Each restored tag is reported to out. A tag already set to another image is
+// a conflict, unless force is true.
+func (srv *Server) ImageLoad(in io.Reader, force bool, out io.Writer, sf *utils.StreamFormatter) error {
 	tmpImageDir, err := ioutil.TempDir("", "docker-import-")
 	if err != nil {
 		return err
@@ -374,11 +430,26 @@ func (srv *Server) ImageLoad(in io.Reader) error {
 			return err
 		}
 
+		// Check all the tags first, so that a conflict leaves them untouched
+		if !force {
+			for imageName, tagMap := range repositories {
+				repo, err := srv.runtime.repositories.Get(imageName)
+				if err != nil {
+					return err
+				}
+				for tag, address := range tagMap {
+					if old, exists := repo[tag]; exists && old != address {
+						return fmt.Errorf("Conflict: Tag %s:%s is already set to %s", imageName, tag, utils.TruncateID(old))
+					}
+				}
+			}
+		}
 		for imageName, tagMap := range repositories {
 			for tag, address := range tagMap {
 				if err := srv.runtime.repositories.Set(imageName, tag, address, true); err != nil {
 					return err
 				}
+				out.Write(sf.FormatStatus("", "Loaded %s:%s (%s)", imageName, tag, utils.TruncateID(address)))
 			}
 		}
 	} else if !os.IsNotExist(err) {
@@ -415,8 +486,29 @@ func (srv *Server) recursiveLoad(address, tmpImageDir string) error {
 				}
 			}
 		}
-		if err := srv.runtime.graph.Register(imageJson, layer, img); err != nil {
+		checksum, err := ioutil.ReadFile(path.Join(tmpImageDir, "repo", address, "checksum"))
+		if os.IsNotExist(err) {
+			if err := srv.runtime.graph.Register(imageJson, layer, img); err != nil {
+				return err
+			}
+		} else if err != nil {
 			return err
+		} else {
+			// The checksum of the archive is only kept if the layer matches it
+			tarsum := &utils.TarSum{Reader: layer}
+			if err := srv.runtime.graph.RegisterChecked(imageJson, tarsum, img, func(root string) error {
+				if _, err := io.Copy(ioutil.Discard, tarsum); err != nil {
+					return err
+				}
+				if sum := tarsum.Sum(imageJson); sum != string(checksum) {
+					utils.Errorf("Checksum mismatch for layer %s: expected %s, got %s, not keeping it", img.ID, checksum, sum)
+					return nil
+				}
+				img.Checksum = string(checksum)
+				return img.SaveChecksum(root)
+			}); err != nil {
+				return err
+			}
 		}
 	}
 	utils.Debugf("Completed processing %s", address)
@@ -509,7 +601,7 @@ func (srv *Server) ImagesViz(out io.Writer) error {
 	return nil
 }
 
-func (srv *Server) Images(all bool, filter string) ([]APIImages, error) {
+func (srv *Server) Images(all bool, filter string, labels []string) ([]APIImages, error) {
 	var (
 		allImages map[string]*Image
 		err       error
@@ -535,22 +627,21 @@ func (srv *Server) Images(all bool, filter string) ([]APIImages, error) {
 				log.Printf("Warning: couldn't load %s from %s/%s: %s", id, name, tag, err)
 				continue
 			}
+			if !image.matchLabels(labels) {
+				delete(allImages, id)
+				continue
+			}
 
 			if out, exists := lookup[id]; exists {
 				out.RepoTags = append(out.RepoTags, fmt.Sprintf("%s:%s", name, tag))
 
 				lookup[id] = out
 			} else {
-				var out APIImages
+				out := newAPIImages(image)
 
 				delete(allImages, id)
 
-				out.ParentId = image.Parent
 				out.RepoTags = []string{fmt.Sprintf("%s:%s", name, tag)}
-				out.ID = image.ID
-				out.Created = image.Created.Unix()
-				out.Size = image.Size
-				out.VirtualSize = image.getParentsSize(0) + image.Size
 
 				lookup[id] = out
 			}
@@ -558,6 +649,43 @@ func (srv *Server) Images(all bool, filter string) ([]APIImages, error) {
 		}
 	}
 
+	// The images referred to by digest are listed under their repository,
+	// even without a tag
+	for name, digests := range srv.runtime.repositories.Digests {
+		if filter != "" {
+			if match, _ := path.Match(filter, name); !match {
+				continue
+			}
+		}
+		for digest, id := range digests {
+			image, err := srv.runtime.graph.Get(id)
+			if err != nil {
+				log.Printf("Warning: couldn't load %s from %s@%s: %s", id, name, digest, err)
+				continue
+			}
+			if !image.matchLabels(labels) {
+				delete(allImages, id)
+				continue
+			}
+			out, exists := lookup[id]
+			if !exists {
+				out = newAPIImages(image)
+				delete(allImages, id)
+			}
+			tagged := false
+			for _, repoTag := range out.RepoTags {
+				if repo, _ := utils.ParseRepositoryTag(repoTag); repo == name {
+					tagged = true
+				}
+			}
+			if !tagged {
+				out.RepoTags = append(out.RepoTags, name+":<none>")
+			}
+			out.RepoDigests = append(out.RepoDigests, name+"@"+digest)
+			lookup[id] = out
+		}
+	}
+
 	outs := make([]APIImages, 0, len(lookup))
 	for _, value := range lookup {
 		outs = append(outs, value)
@@ -566,6 +694,9 @@ func (srv *Server) Images(all bool, filter string) ([]APIImages, error) {
 	// Display images which aren't part of a repository/tag
 	if filter == "" {
 		for _, image := range allImages {
+			if !image.matchLabels(labels) {
+				continue
+			}
 			var out APIImages
 			out.ID = image.ID
 			out.ParentId = image.Parent
@@ -573,6 +704,9 @@ func (srv *Server) Images(all bool, filter string) ([]APIImages, error) {
 			out.Created = image.Created.Unix()
 			out.Size = image.Size
 			out.VirtualSize = image.getParentsSize(0) + image.Size
+			if image.Config != nil {
+				out.Labels = image.Config.Labels
+			}
 			outs = append(outs, out)
 		}
 	}
@@ -581,6 +715,20 @@ func (srv *Server) Images(all bool, filter string) ([]APIImages, error) {
 	return outs, nil
 }
 
+func newAPIImages(image *Image) APIImages {
+	out := APIImages{
+		ID:          image.ID,
+		ParentId:    image.Parent,
+		Created:     image.Created.Unix(),
+		Size:        image.Size,
+		VirtualSize: image.getParentsSize(0) + image.Size,
+	}
+	if image.Config != nil {
+		out.Labels = image.Config.Labels
+	}
+	return out
+}
+
 func (srv *Server) DockerInfo() *APIInfo {
 	images, _ := srv.runtime.graph.Map()
 	var imgcount int
@@ -600,6 +748,10 @@ func (srv *Server) DockerInfo() *APIInfo {
 	if kv, err := utils.GetKernelVersion(); err == nil {
 		kernelVersion = kv.String()
 	}
+	var mirrors [][2]string
+	for _, m := range srv.mirrors {
+		mirrors = append(mirrors, [2]string{m.Endpoint, m.Status()})
+	}
 
 	return &APIInfo{
 		Containers:         len(srv.runtime.List()),
@@ -616,6 +768,7 @@ func (srv *Server) DockerInfo() *APIInfo {
 		NEventsListener:    len(srv.events),
 		KernelVersion:      kernelVersion,
 		IndexServerAddress: auth.IndexServerAddress(),
+		RegistryMirrors:    mirrors,
 	}
 }
 
@@ -695,7 +848,63 @@ func (srv *Server) ContainerChanges(name string) ([]archive.Change, error) {
 	return nil, fmt.Errorf("No such container: %s", name)
 }
 
-func (srv *Server) Containers(all, size bool, n int, since, before string) []APIContainers {
+// ContainersDiff returns the changes between the filesystems of the
+// containers `from` and `to`
+func (srv *Server) ContainersDiff(from, to string) ([]APIChange, error) {
+	fromContainer := srv.runtime.Get(from)
+	if fromContainer == nil {
+		return nil, fmt.Errorf("No such container: %s", from)
+	}
+	toContainer := srv.runtime.Get(to)
+	if toContainer == nil {
+		return nil, fmt.Errorf("No such container: %s", to)
+	}
+	return srv.changesBetween(fromContainer.ID, toContainer.ID)
+}
+
+// ImagesDiff returns the changes between the filesystems of the images
+// `from` and `to`
+func (srv *Server) ImagesDiff(from, to string) ([]APIChange, error) {
+	fromImage, err := srv.runtime.repositories.LookupImage(from)
+	if err != nil {
+		return nil, fmt.Errorf("No such image: %s", from)
+	}
+	toImage, err := srv.runtime.repositories.LookupImage(to)
+	if err != nil {
+		return nil, fmt.Errorf("No such image: %s", to)
+	}
+	return srv.changesBetween(fromImage.ID, toImage.ID)
+}
+
+// changesBetween compares the directories mounted by the driver for the
+// layers `fromID` and `toID`, which can be images as well as containers.
+func (srv *Server) changesBetween(fromID, toID string) ([]APIChange, error) {
+	driver := srv.runtime.driver
+	fromDir, err := driver.Get(fromID)
+	if err != nil {
+		return nil, fmt.Errorf("Error getting rootfs %s from driver %s: %s", fromID, driver, err)
+	}
+	toDir, err := driver.Get(toID)
+	if err != nil {
+		return nil, fmt.Errorf("Error getting rootfs %s from driver %s: %s", toID, driver, err)
+	}
+	changes, err := archive.ChangesDirs(toDir, fromDir)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]APIChange, 0, len(changes))
+	for _, change := range changes {
+		out = append(out, APIChange{
+			Path:      change.Path,
+			Kind:      change.Kind,
+			SizeDelta: change.SizeDelta(toDir, fromDir),
+		})
+	}
+	sortChangesByPath(out)
+	return out, nil
+}
+
+func (srv *Server) Containers(all, size bool, n int, since, before string, labels []string) []APIContainers {
 	var foundBefore bool
 	var displayed int
 	out := []APIContainers{}
@@ -722,6 +931,9 @@ func (srv *Server) Containers(all, size bool, n int, since, before string) []API
 		if container.ID == since || utils.TruncateID(container.ID) == since {
 			break
 		}
+		if !matchLabels(container.Config.Labels, labels) {
+			continue
+		}
 		displayed++
 		c := createAPIContainer(names[container.ID], container, size, srv.runtime)
 		out = append(out, c)
@@ -739,6 +951,7 @@ func createAPIContainer(names []string, container *Container, size bool, runtime
 	c.Created = container.Created.Unix()
 	c.Status = container.State.String()
 	c.Ports = container.NetworkSettings.PortMappingAPI()
+	c.Labels = container.Config.Labels
 	if size {
 		c.SizeRw, c.SizeRootFs = container.GetSize()
 	}
@@ -764,15 +977,44 @@ func (srv *Server) ContainerTag(name, repo, tag string, force bool) error {
 	return nil
 }
 
-func (srv *Server) pullImage(r *registry.Registry, out io.Writer, imgID, endpoint string, token []string, sf *utils.StreamFormatter) error {
+// layerDownload is a layer of an image being pulled. Its json and its fs
+// layer are fetched concurrently with the other layers, then registered in
+// the graph once all its parents are.
+type layerDownload struct {
+	id      string
+	imgJSON []byte
+	img     *Image
+	layer   *archive.TempArchive
+	err     error
+	done    chan struct{}
+}
+
+// pullImage pulls imgID and its parents from endpoint. The layers listed in
+// checksums are verified against their checksum.
+func (srv *Server) pullImage(r *registry.Registry, out io.Writer, imgID, endpoint string, token []string, checksums map[string]string, sf *utils.StreamFormatter) error {
 	history, err := r.GetRemoteHistory(imgID, endpoint, token)
 	if err != nil {
 		return err
 	}
 	out.Write(sf.FormatProgress(utils.TruncateID(imgID), "Pulling dependent layers", nil))
 	// FIXME: Try to stream the images?
-	// FIXME: Launch the getRemoteImage() in goroutines
 
+	// The layers are buffered here until they can be registered
+	tmp, err := srv.runtime.graph.Mktemp("")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	limit := srv.runtime.config.MaxConcurrentDownloads
+	if limit < 1 {
+		limit = 1
+	}
+	var (
+		slots     = make(chan struct{}, limit)
+		abort     = make(chan struct{})
+		downloads = make([]*layerDownload, 0, len(history))
+	)
 	for i := len(history) - 1; i >= 0; i-- {
 		id := history[i]
 
@@ -780,39 +1022,138 @@ func (srv *Server) pullImage(r *registry.Registry, out io.Writer, imgID, endpoin
 		if c, err := srv.poolAdd("pull", "layer:"+id); err != nil {
 			utils.Errorf("Image (id: %s) pull is already running, skipping: %v", id, err)
 			<-c
+		} else {
+			defer srv.poolRemove("pull", "layer:"+id)
 		}
-		defer srv.poolRemove("pull", "layer:"+id)
 
-		if !srv.runtime.graph.Exists(id) {
-			out.Write(sf.FormatProgress(utils.TruncateID(id), "Pulling metadata", nil))
-			imgJSON, imgSize, err := r.GetRemoteImageJSON(id, endpoint, token)
-			if err != nil {
-				out.Write(sf.FormatProgress(utils.TruncateID(id), "Error pulling dependent layers", nil))
-				// FIXME: Keep going in case of error?
-				return err
-			}
-			img, err := NewImgJSON(imgJSON)
-			if err != nil {
-				out.Write(sf.FormatProgress(utils.TruncateID(id), "Error pulling dependent layers", nil))
-				return fmt.Errorf("Failed to parse json: %s", err)
+		d := &layerDownload{id: id, done: make(chan struct{})}
+		downloads = append(downloads, d)
+		if srv.runtime.graph.Exists(id) {
+			close(d.done)
+			continue
+		}
+		go func() {
+			defer close(d.done)
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+			case <-abort:
+				return
 			}
+			d.err = srv.downloadLayer(r, out, d, endpoint, token, tmp, sf)
+		}()
+	}
 
-			// Get the layer
-			out.Write(sf.FormatProgress(utils.TruncateID(id), "Pulling fs layer", nil))
-			layer, err := r.GetRemoteImageLayer(img.ID, endpoint, token)
-			if err != nil {
-				out.Write(sf.FormatProgress(utils.TruncateID(id), "Error pulling dependent layers", nil))
-				return err
+	// Register the layers parent first, as soon as they are downloaded.
+	// In case of error, wait for the running downloads before returning.
+	var firstErr error
+	for _, d := range downloads {
+		<-d.done
+		if firstErr == nil && d.err != nil {
+			firstErr = d.err
+			close(abort)
+		}
+		if firstErr != nil {
+			if d.layer != nil {
+				d.layer.Close()
 			}
-			defer layer.Close()
-			if err := srv.runtime.graph.Register(imgJSON, utils.ProgressReader(layer, imgSize, out, sf, false, utils.TruncateID(id), "Downloading"), img); err != nil {
-				out.Write(sf.FormatProgress(utils.TruncateID(id), "Error downloading dependent layers", nil))
-				return err
+			continue
+		}
+		if d.layer != nil {
+			if err := srv.registerLayer(d, checksums[d.id]); err != nil {
+				out.Write(sf.FormatProgress(utils.TruncateID(d.id), "Error downloading dependent layers", nil))
+				firstErr = err
+				close(abort)
+				continue
 			}
 		}
-		out.Write(sf.FormatProgress(utils.TruncateID(id), "Download complete", nil))
+		out.Write(sf.FormatProgress(utils.TruncateID(d.id), "Download complete", nil))
+	}
+	return firstErr
+}
+
+// registerLayer registers the downloaded layer `d` in the graph, computing
+// its TarSum on the way. The image is only added to the graph if the
+// checksum matches the expected one, and the checksum is then stored with
+// it.
+func (srv *Server) registerLayer(d *layerDownload, expected string) error {
+	defer d.layer.Close()
+	layer, err := archive.DecompressStream(d.layer)
+	if err != nil {
+		return err
+	}
+	tarsum := &utils.TarSum{Reader: layer}
+	return srv.runtime.graph.RegisterChecked(d.imgJSON, tarsum, d.img, func(root string) error {
+		// The layer may not have been read until its very end
+		if _, err := io.Copy(ioutil.Discard, tarsum); err != nil {
+			return err
+		}
+		if expected == "" {
+			return nil
+		}
+		if checksum := tarsum.Sum(d.imgJSON); checksum != expected {
+			return fmt.Errorf("Checksum mismatch for layer %s: expected %s, got %s", d.img.ID, expected, checksum)
+		}
+		d.img.Checksum = expected
+		return d.img.SaveChecksum(root)
+	})
+}
 
+// layerDownloadRetries is the number of times an interrupted layer
+// download is resumed before giving up
+const layerDownloadRetries = 5
+
+// downloadLayer fetches the json and the fs layer of `d` and spools the
+// layer to a temporary file under tmp. When the transfer is interrupted,
+// it is resumed where it stopped, up to layerDownloadRetries times.
+func (srv *Server) downloadLayer(r *registry.Registry, out io.Writer, d *layerDownload, endpoint string, token []string, tmp string, sf *utils.StreamFormatter) error {
+	out.Write(sf.FormatProgress(utils.TruncateID(d.id), "Pulling metadata", nil))
+	imgJSON, imgSize, err := r.GetRemoteImageJSON(d.id, endpoint, token)
+	if err != nil {
+		out.Write(sf.FormatProgress(utils.TruncateID(d.id), "Error pulling dependent layers", nil))
+		return err
 	}
+	img, err := NewImgJSON(imgJSON)
+	if err != nil {
+		out.Write(sf.FormatProgress(utils.TruncateID(d.id), "Error pulling dependent layers", nil))
+		return fmt.Errorf("Failed to parse json: %s", err)
+	}
+
+	// Get the layer
+	out.Write(sf.FormatProgress(utils.TruncateID(d.id), "Pulling fs layer", nil))
+	f, err := ioutil.TempFile(tmp, "layer-")
+	if err != nil {
+		return err
+	}
+	var downloaded int64
+	for retries := 0; ; retries++ {
+		layer, err := r.GetRemoteImageLayer(img.ID, endpoint, token, downloaded)
+		if err == nil {
+			var n int64
+			n, err = io.Copy(f, utils.ProgressReader(layer, imgSize-int(downloaded), out, sf, false, utils.TruncateID(d.id), "Downloading"))
+			downloaded += n
+			layer.Close()
+			if err == nil {
+				break
+			}
+		}
+		// Errors returned by the registry itself won't go away by retrying
+		if jsonErr, ok := err.(*utils.JSONError); (ok && jsonErr.Code < 500) || retries >= layerDownloadRetries {
+			f.Close()
+			out.Write(sf.FormatProgress(utils.TruncateID(d.id), "Error pulling dependent layers", nil))
+			return err
+		}
+		delay := 1 << uint(retries)
+		utils.Errorf("Download of layer %s interrupted after %d bytes: %s", d.id, downloaded, err)
+		out.Write(sf.FormatProgress(utils.TruncateID(d.id), fmt.Sprintf("Retrying in %d seconds", delay), nil))
+		time.Sleep(time.Duration(delay) * time.Second)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		f.Close()
+		return err
+	}
+	d.layer = &archive.TempArchive{File: f, Size: downloaded}
+	d.imgJSON, d.img = imgJSON, img
 	return nil
 }
 
@@ -824,18 +1165,51 @@ func (srv *Server) pullRepository(r *registry.Registry, out io.Writer, localName
 		return err
 	}
 
+	// Images of the official index are looked up on the mirrors first
+	var mirrors []*registry.Mirror
+	if indexEp == auth.IndexServerAddress() {
+		for _, m := range srv.mirrors {
+			if m.Healthy() {
+				mirrors = append(mirrors, m)
+			}
+		}
+	}
+
+	if utils.IsDigest(askedTag) {
+		return srv.pullDigest(r, out, localName, remoteName, askedTag, repoData, mirrors, sf)
+	}
+
 	utils.Debugf("Retrieving the tag list")
-	tagsList, err := r.GetRemoteTags(repoData.Endpoints, remoteName, repoData.Tokens)
-	if err != nil {
-		utils.Errorf("%v", err)
-		return err
+	var tagsList map[string]string
+	for _, m := range mirrors {
+		if tagsList, err = r.GetRemoteTags([]string{m.Endpoint}, remoteName, repoData.Tokens); err == nil {
+			break
+		}
+		utils.Debugf("Could not retrieve the tag list from mirror %s: %s", m.Endpoint, err)
+	}
+	if tagsList == nil {
+		tagsList, err = r.GetRemoteTags(repoData.Endpoints, remoteName, repoData.Tokens)
+		if err != nil {
+			utils.Errorf("%v", err)
+			return err
+		}
 	}
 
 	for tag, id := range tagsList {
-		repoData.ImgList[id] = &registry.ImgData{
-			ID:       id,
-			Tag:      tag,
-			Checksum: "",
+		// Keep the checksum given by the index, if any
+		if img, exists := repoData.ImgList[id]; exists {
+			img.Tag = tag
+		} else {
+			repoData.ImgList[id] = &registry.ImgData{
+				ID:  id,
+				Tag: tag,
+			}
+		}
+	}
+	checksums := make(map[string]string)
+	for id, img := range repoData.ImgList {
+		if img.Checksum != "" {
+			checksums[id] = img.Checksum
 		}
 	}
 
@@ -886,9 +1260,23 @@ func (srv *Server) pullRepository(r *registry.Registry, out io.Writer, localName
 			out.Write(sf.FormatProgress(utils.TruncateID(img.ID), fmt.Sprintf("Pulling image (%s) from %s", img.Tag, localName), nil))
 			success := false
 			var lastErr error
+			for _, m := range mirrors {
+				out.Write(sf.FormatProgress(utils.TruncateID(img.ID), fmt.Sprintf("Pulling image (%s) from %s, mirror: %s", img.Tag, localName, m.Endpoint), nil))
+				if err := srv.pullImage(r, out, img.ID, m.Endpoint, repoData.Tokens, checksums, sf); err != nil {
+					// Don't give up on the mirror errors, the index registries are tried next
+					utils.Debugf("Error pulling image (%s) from %s, mirror: %s, %s", img.Tag, localName, m.Endpoint, err)
+					m.Fail(err)
+					continue
+				}
+				success = true
+				break
+			}
 			for _, ep := range repoData.Endpoints {
+				if success {
+					break
+				}
 				out.Write(sf.FormatProgress(utils.TruncateID(img.ID), fmt.Sprintf("Pulling image (%s) from %s, endpoint: %s", img.Tag, localName, ep), nil))
-				if err := srv.pullImage(r, out, img.ID, ep, repoData.Tokens, sf); err != nil {
+				if err := srv.pullImage(r, out, img.ID, ep, repoData.Tokens, checksums, sf); err != nil {
 					// Its not ideal that only the last error  is returned, it would be better to concatenate the errors.
 					// As the error is also given to the output stream the user will see the error.
 					lastErr = err
@@ -937,6 +1325,7 @@ func (srv *Server) pullRepository(r *registry.Registry, out io.Writer, localName
 		if err := srv.runtime.repositories.Set(localName, tag, id, true); err != nil {
 			return err
 		}
+		srv.pullTagDigest(r, localName, remoteName, tag, id, repoData)
 	}
 	if err := srv.runtime.repositories.Save(); err != nil {
 		return err
@@ -945,6 +1334,94 @@ func (srv *Server) pullRepository(r *registry.Registry, out io.Writer, localName
 	return nil
 }
 
+// pullDigest pulls the image whose manifest has the given digest, and
+// refers to it by digest only.
+func (srv *Server) pullDigest(r *registry.Registry, out io.Writer, localName, remoteName, digest string, repoData *registry.RepositoryData, mirrors []*registry.Mirror, sf *utils.StreamFormatter) error {
+	var endpoints []string
+	for _, m := range mirrors {
+		endpoints = append(endpoints, m.Endpoint)
+	}
+	endpoints = append(endpoints, repoData.Endpoints...)
+
+	lastErr := fmt.Errorf("Could not find repository on any of the indexed registries.")
+	for _, ep := range endpoints {
+		manifest, _, err := r.GetManifest(remoteName, digest, ep, repoData.Tokens)
+		if err != nil {
+			utils.Debugf("Error fetching the manifest %s of %s from %s: %s", digest, localName, ep, err)
+			lastErr = err
+			continue
+		}
+		if len(manifest.Layers) == 0 {
+			return fmt.Errorf("Invalid manifest %s: no layers", digest)
+		}
+		checksums := make(map[string]string)
+		for _, layer := range manifest.Layers {
+			checksums[layer.ID] = layer.Checksum
+		}
+		id := manifest.Layers[len(manifest.Layers)-1].ID
+
+		out.Write(sf.FormatProgress(utils.TruncateID(id), fmt.Sprintf("Pulling image (%s) from %s, endpoint: %s", digest, localName, ep), nil))
+		if err := srv.pullImage(r, out, id, ep, repoData.Tokens, checksums, sf); err != nil {
+			out.Write(sf.FormatProgress(utils.TruncateID(id), fmt.Sprintf("Error pulling image (%s) from %s, endpoint: %s, %s", digest, localName, ep, err), nil))
+			lastErr = err
+			continue
+		}
+		out.Write(sf.FormatProgress(utils.TruncateID(id), "Download complete", nil))
+
+		if err := srv.checkManifest(id, manifest); err != nil {
+			return err
+		}
+		if err := srv.runtime.repositories.SetDigest(localName, digest, id); err != nil {
+			return err
+		}
+		out.Write(sf.FormatStatus("", "Digest: %s", digest))
+		return nil
+	}
+	return lastErr
+}
+
+// checkManifest returns an error if the history of the image id is not the
+// list of layers of manifest.
+func (srv *Server) checkManifest(id string, manifest *registry.Manifest) error {
+	img, err := srv.runtime.graph.Get(id)
+	if err != nil {
+		return err
+	}
+	history, err := img.History()
+	if err != nil {
+		return err
+	}
+	if len(history) != len(manifest.Layers) {
+		return fmt.Errorf("Image %s does not match its manifest: %d layers, expected %d", id, len(history), len(manifest.Layers))
+	}
+	for i, layer := range manifest.Layers {
+		if img := history[len(history)-1-i]; img.ID != layer.ID {
+			return fmt.Errorf("Image %s does not match its manifest: layer %s, expected %s", id, img.ID, layer.ID)
+		}
+	}
+	return nil
+}
+
+// pullTagDigest records the digest of the manifest of the pulled tag, if
+// the registry has one for this image.
+func (srv *Server) pullTagDigest(r *registry.Registry, localName, remoteName, tag, id string, repoData *registry.RepositoryData) {
+	for _, ep := range repoData.Endpoints {
+		manifest, digest, err := r.GetManifest(remoteName, tag, ep, repoData.Tokens)
+		if err != nil {
+			utils.Debugf("No manifest for %s:%s on %s: %s", localName, tag, ep, err)
+			continue
+		}
+		if err := srv.checkManifest(id, manifest); err != nil {
+			utils.Debugf("%s", err)
+			continue
+		}
+		if err := srv.runtime.repositories.SetDigest(localName, digest, id); err != nil {
+			utils.Errorf("Error recording the digest of %s:%s: %s", localName, tag, err)
+		}
+		return
+	}
+}
+
 func (srv *Server) poolAdd(kind, key string) (chan struct{}, error) {
 	srv.Lock()
 	defer srv.Unlock()
@@ -1017,6 +1494,10 @@ func (srv *Server) ImagePull(localName string, tag string, out io.Writer, sf *ut
 	if endpoint == auth.IndexServerAddress() {
 		// If pull "index.docker.io/foo/bar", it's stored locally under "foo/bar"
 		localName = remoteName
+	} else if registry.IsDirEndpoint(endpoint) {
+		// If pull "dir:///mnt/images/app", it's stored locally under "dir:/mnt/images/app",
+		// which can be part of the remote api urls
+		localName = path.Clean(localName)
 	}
 
 	if err = srv.pullRepository(r, out, localName, remoteName, tag, endpoint, sf, parallel); err != nil {
@@ -1040,8 +1521,9 @@ func (srv *Server) getImageList(localRepo map[string]string) ([][]*registry.ImgD
 		depGraph.NewNode(img.ID)
 		img.WalkHistory(func(current *Image) error {
 			imgList[current.ID] = &registry.ImgData{
-				ID:  current.ID,
-				Tag: tag,
+				ID:       current.ID,
+				Tag:      tag,
+				Checksum: current.Checksum,
 			}
 			parent, err := current.GetParent()
 			if err != nil {
@@ -1096,45 +1578,56 @@ func (srv *Server) pushRepository(r *registry.Registry, out io.Writer, localName
 		return err
 	}
 
+	// The checksums of the layers, as known to the registry, for the manifests
+	checksums := make(map[string]string)
+
 	for _, ep := range repoData.Endpoints {
 		out.Write(sf.FormatStatus("", "Pushing repository %s (%d tags)", localName, len(localRepo)))
-		// This section can not be parallelized (each round depends on the previous one)
-		for _, round := range imgList {
-			// FIXME: This section can be parallelized
-			for _, elem := range round {
-				var pushTags func() error
-				pushTags = func() error {
-					out.Write(sf.FormatStatus("", "Pushing tags for rev [%s] on {%s}", elem.ID, ep+"repositories/"+remoteName+"/tags/"+elem.Tag))
-					if err := r.PushRegistryTag(remoteName, elem.ID, elem.Tag, ep, repoData.Tokens); err != nil {
-						return err
-					}
-					return nil
-				}
-				if _, exists := repoData.ImgList[elem.ID]; exists {
-					if err := pushTags(); err != nil {
-						return err
-					}
-					out.Write(sf.FormatStatus("", "Image %s already pushed, skipping", elem.ID))
-					continue
-				} else if r.LookupRemoteImage(elem.ID, ep, repoData.Tokens) {
-					if err := pushTags(); err != nil {
-						return err
-					}
-					out.Write(sf.FormatStatus("", "Image %s already pushed, skipping", elem.ID))
-					continue
-				}
-				checksum, err := srv.pushImage(r, out, remoteName, elem.ID, ep, repoData.Tokens, sf)
-				if err != nil {
-					// FIXME: Continue on error?
-					return err
-				}
+
+		// Find out up front which images the registry already has, so that
+		// only the missing layers get archived and uploaded
+		var unknown []string
+		for _, elem := range flattenedImgList {
+			if _, exists := repoData.ImgList[elem.ID]; !exists {
+				unknown = append(unknown, elem.ID)
+			}
+		}
+		remoteImages := r.LookupRemoteImages(unknown, ep, repoData.Tokens)
+
+		stats := &pushStats{}
+		var missing []*registry.ImgData
+		for _, elem := range flattenedImgList {
+			_, exists := repoData.ImgList[elem.ID]
+			checksum, found := remoteImages[elem.ID]
+			if !exists && !found {
+				missing = append(missing, elem)
+				continue
+			}
+			out.Write(sf.FormatProgress(utils.TruncateID(elem.ID), "Already pushed, skipping", nil))
+			stats.add(false, srv.imageSize(elem.ID))
+			// The checksum given by the registry is only used for the
+			// manifests of this push: it isn't verified against the layer,
+			// so it isn't stored with the image
+			if checksum != "" {
 				elem.Checksum = checksum
+				checksums[elem.ID] = checksum
+			}
+		}
+		if err := srv.pushImages(r, out, missing, ep, repoData.Tokens, checksums, stats, sf); err != nil {
+			return err
+		}
 
-				if err := pushTags(); err != nil {
-					return err
-				}
+		for tag, id := range localRepo {
+			out.Write(sf.FormatStatus("", "Pushing tag for rev [%s] on {%s}", id, ep+"repositories/"+remoteName+"/tags/"+tag))
+			if err := r.PushRegistryTag(remoteName, id, tag, ep, repoData.Tokens); err != nil {
+				return err
 			}
 		}
+		out.Write(sf.FormatStatus("", "Pushed %d layers (%s), reused %d layers already in the registry (%s)", stats.uploaded, utils.HumanSize(stats.uploadedSize), stats.reused, utils.HumanSize(stats.reusedSize)))
+
+		if err := srv.pushManifests(r, out, localName, remoteName, localRepo, checksums, ep, repoData.Tokens, sf); err != nil {
+			return err
+		}
 	}
 
 	if _, err := r.PushImageJSONIndex(indexEp, remoteName, flattenedImgList, true, repoData.Endpoints); err != nil {
@@ -1144,52 +1637,260 @@ func (srv *Server) pushRepository(r *registry.Registry, out io.Writer, localName
 	return nil
 }
 
-func (srv *Server) pushImage(r *registry.Registry, out io.Writer, remote, imgID, ep string, token []string, sf *utils.StreamFormatter) (checksum string, err error) {
-	out = utils.NewWriteFlusher(out)
+// pushManifests uploads the manifest of each tag of localRepo, and records
+// their digests.
+func (srv *Server) pushManifests(r *registry.Registry, out io.Writer, localName, remoteName string, localRepo map[string]string, checksums map[string]string, ep string, token []string, sf *utils.StreamFormatter) error {
+	key, err := registry.LoadManifestKey(path.Join(srv.runtime.config.Root, "manifest-key.pem"))
+	if err != nil {
+		return err
+	}
+	for tag, id := range localRepo {
+		manifest, err := srv.newManifest(remoteName, tag, id, checksums)
+		if err != nil {
+			return err
+		}
+		if err := manifest.Sign(key); err != nil {
+			return err
+		}
+		digest, err := r.PushManifest(remoteName, tag, manifest, ep, token)
+		if err == registry.ErrManifestsUnsupported {
+			out.Write(sf.FormatStatus("", "The registry does not support manifests, skipping"))
+			return nil
+		} else if err != nil {
+			return err
+		}
+		out.Write(sf.FormatStatus("", "%s: digest: %s", tag, digest))
+		if err := srv.runtime.repositories.SetDigest(localName, digest, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newManifest returns the unsigned manifest of the image id, tagged
+// name:tag. The checksums missing from checksums, and unknown to the image,
+// are computed.
+func (srv *Server) newManifest(name, tag, id string, checksums map[string]string) (*registry.Manifest, error) {
+	img, err := srv.runtime.graph.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	history, err := img.History()
+	if err != nil {
+		return nil, err
+	}
+	manifest := &registry.Manifest{SchemaVersion: 1, Name: name, Tag: tag}
+	for i := len(history) - 1; i >= 0; i-- {
+		layer := &registry.ManifestLayer{ID: history[i].ID, Checksum: checksums[history[i].ID]}
+		if layer.Checksum == "" {
+			layer.Checksum = history[i].Checksum
+		}
+		if layer.Checksum == "" {
+			if layer.Checksum, err = srv.layerChecksum(history[i]); err != nil {
+				return nil, err
+			}
+			if err := srv.saveChecksum(history[i].ID, layer.Checksum); err != nil {
+				return nil, err
+			}
+		}
+		manifest.Layers = append(manifest.Layers, layer)
+	}
+	return manifest, nil
+}
+
+// layerChecksum computes the TarSum of the layer of img, as a registry does.
+func (srv *Server) layerChecksum(img *Image) (string, error) {
+	jsonRaw, err := ioutil.ReadFile(path.Join(srv.runtime.graph.Root, img.ID, "json"))
+	if err != nil {
+		return "", err
+	}
+	layer, err := img.TarLayer()
+	if err != nil {
+		return "", err
+	}
+	tarsum := &utils.TarSum{Reader: layer}
+	if _, err := io.Copy(ioutil.Discard, tarsum); err != nil {
+		return "", err
+	}
+	return tarsum.Sum(jsonRaw), nil
+}
+
+// saveChecksum stores the checksum of the layer of the image id, so that the
+// next pushes don't compute it again. It must have been computed from the
+// layer, or verified against it.
+func (srv *Server) saveChecksum(id, checksum string) error {
+	img, err := srv.runtime.graph.Get(id)
+	if err != nil {
+		return err
+	}
+	if img.Checksum == checksum {
+		return nil
+	}
+	img.Checksum = checksum
+	return img.SaveChecksum(srv.runtime.graph.imageRoot(id))
+}
+
+// pushStats counts the layers uploaded and reused by a push, with their size.
+type pushStats struct {
+	sync.Mutex
+	uploaded, reused         int
+	uploadedSize, reusedSize int64
+}
+
+func (s *pushStats) add(uploaded bool, size int64) {
+	s.Lock()
+	defer s.Unlock()
+	if uploaded {
+		s.uploaded++
+		s.uploadedSize += size
+	} else {
+		s.reused++
+		s.reusedSize += size
+	}
+}
+
+// imageSize returns the size of the layer of the image id, 0 if unknown.
+func (srv *Server) imageSize(id string) int64 {
+	img, err := srv.runtime.graph.Get(id)
+	if err != nil {
+		return 0
+	}
+	return img.Size
+}
+
+func (srv *Server) imageJSON(imgID string) ([]byte, error) {
 	jsonRaw, err := ioutil.ReadFile(path.Join(srv.runtime.graph.Root, imgID, "json"))
 	if err != nil {
-		return "", fmt.Errorf("Cannot retrieve the path for {%s}: %s", imgID, err)
+		return nil, fmt.Errorf("Cannot retrieve the path for {%s}: %s", imgID, err)
 	}
-	out.Write(sf.FormatStatus("", "Pushing %s", imgID))
+	return jsonRaw, nil
+}
+
+// pushImages uploads the images of imgList, parents first. Their json are
+// sent one after the other, as a registry may require the parent of an
+// image to exist. Then up to MaxConcurrentUploads layers are archived and
+// uploaded at the same time. The checksums of the uploaded layers are added
+// to checksums, and stored with the images.
+func (srv *Server) pushImages(r *registry.Registry, out io.Writer, imgList []*registry.ImgData, ep string, token []string, checksums map[string]string, stats *pushStats, sf *utils.StreamFormatter) error {
+	var (
+		toUpload []*registry.ImgData
+		jsons    = make(map[string][]byte)
+	)
+	for _, elem := range imgList {
+		jsonRaw, err := srv.imageJSON(elem.ID)
+		if err != nil {
+			return err
+		}
+		out.Write(sf.FormatProgress(utils.TruncateID(elem.ID), "Pushing metadata", nil))
+		if err := r.PushImageJSONRegistry(&registry.ImgData{ID: elem.ID}, jsonRaw, ep, token); err != nil {
+			if err == registry.ErrAlreadyExists {
+				out.Write(sf.FormatProgress(utils.TruncateID(elem.ID), "Already pushed, skipping", nil))
+				stats.add(false, srv.imageSize(elem.ID))
+				continue
+			}
+			return err
+		}
+		jsons[elem.ID] = jsonRaw
+		toUpload = append(toUpload, elem)
+	}
+
+	limit := srv.runtime.config.MaxConcurrentUploads
+	if limit < 1 {
+		limit = 1
+	}
+	var (
+		lock     sync.Mutex
+		wg       sync.WaitGroup
+		slots    = make(chan struct{}, limit)
+		firstErr error
+	)
+	for _, elem := range toUpload {
+		wg.Add(1)
+		go func(elem *registry.ImgData) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			lock.Lock()
+			failed := firstErr != nil
+			lock.Unlock()
+			if failed {
+				return
+			}
+			checksum, size, err := srv.pushImageLayer(r, out, elem.ID, jsons[elem.ID], ep, token, sf)
+
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			if err := srv.saveChecksum(elem.ID, checksum); err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			elem.Checksum = checksum
+			checksums[elem.ID] = checksum
+			stats.add(true, size)
+		}(elem)
+	}
+	wg.Wait()
+	return firstErr
+}
 
-	imgData := &registry.ImgData{
-		ID: imgID,
+func (srv *Server) pushImage(r *registry.Registry, out io.Writer, remote, imgID, ep string, token []string, sf *utils.StreamFormatter) (checksum string, err error) {
+	out = utils.NewWriteFlusher(out)
+	jsonRaw, err := srv.imageJSON(imgID)
+	if err != nil {
+		return "", err
 	}
+	out.Write(sf.FormatStatus("", "Pushing %s", imgID))
 
 	// Send the json
-	if err := r.PushImageJSONRegistry(imgData, jsonRaw, ep, token); err != nil {
+	if err := r.PushImageJSONRegistry(&registry.ImgData{ID: imgID}, jsonRaw, ep, token); err != nil {
 		if err == registry.ErrAlreadyExists {
-			out.Write(sf.FormatStatus("", "Image %s already pushed, skipping", imgData.ID))
+			out.Write(sf.FormatStatus("", "Image %s already pushed, skipping", imgID))
 			return "", nil
 		}
 		return "", err
 	}
+	if checksum, _, err = srv.pushImageLayer(r, out, imgID, jsonRaw, ep, token, sf); err != nil {
+		return "", err
+	}
+	return checksum, srv.saveChecksum(imgID, checksum)
+}
 
+// pushImageLayer uploads the layer of imgID and its checksum, once its json
+// has been pushed. It returns the checksum and the size of the layer.
+func (srv *Server) pushImageLayer(r *registry.Registry, out io.Writer, imgID string, jsonRaw []byte, ep string, token []string, sf *utils.StreamFormatter) (string, int64, error) {
 	layerData, err := srv.runtime.graph.TempLayerArchive(imgID, archive.Uncompressed, sf, out)
 	if err != nil {
-		return "", fmt.Errorf("Failed to generate layer archive: %s", err)
+		return "", 0, fmt.Errorf("Failed to generate layer archive: %s", err)
 	}
 	defer os.RemoveAll(layerData.Name())
 
 	// Send the layer
-	checksum, err = r.PushImageLayerRegistry(imgData.ID, utils.ProgressReader(layerData, int(layerData.Size), out, sf, false, "", "Pushing"), ep, token, jsonRaw)
+	checksum, err := r.PushImageLayerRegistry(imgID, utils.ProgressReader(layerData, int(layerData.Size), out, sf, false, utils.TruncateID(imgID), "Pushing"), ep, token, jsonRaw)
 	if err != nil {
-		return "", err
+		return "", 0, err
 	}
-	imgData.Checksum = checksum
-
-	out.Write(sf.FormatStatus("", ""))
 
 	// Send the checksum
-	if err := r.PushImageChecksumRegistry(imgData, ep, token); err != nil {
-		return "", err
+	if err := r.PushImageChecksumRegistry(&registry.ImgData{ID: imgID, Checksum: checksum}, ep, token); err != nil {
+		return "", 0, err
 	}
-
-	return imgData.Checksum, nil
+	out.Write(sf.FormatProgress(utils.TruncateID(imgID), "Image successfully pushed", nil))
+	return checksum, layerData.Size, nil
 }
 
 // FIXME: Allow to interrupt current push when new push of same image is done.
-func (srv *Server) ImagePush(localName string, out io.Writer, sf *utils.StreamFormatter, authConfig *auth.AuthConfig, metaHeaders map[string][]string) error {
+// ImagePush pushes the image or the repository localName, or only its tag
+// `tag` if it isn't empty.
+func (srv *Server) ImagePush(localName, tag string, out io.Writer, sf *utils.StreamFormatter, authConfig *auth.AuthConfig, metaHeaders map[string][]string) error {
 	if _, err := srv.poolAdd("push", localName); err != nil {
 		return err
 	}
@@ -1213,6 +1914,13 @@ func (srv *Server) ImagePush(localName string, out io.Writer, sf *utils.StreamFo
 		out.Write(sf.FormatStatus("", "The push refers to a repository [%s] (len: %d)", localName, reposLen))
 		// If it fails, try to get the repository
 		if localRepo, exists := srv.runtime.repositories.Repositories[localName]; exists {
+			if tag != "" {
+				id, exists := localRepo[tag]
+				if !exists {
+					return fmt.Errorf("Tag %s not found in repository %s", tag, localName)
+				}
+				localRepo = map[string]string{tag: id}
+			}
 			if err := srv.pushRepository(r, out, localName, remoteName, localRepo, endpoint, sf); err != nil {
 				return err
 			}
@@ -1294,7 +2002,7 @@ func (srv *Server) ContainerCreate(job *engine.Job) engine.Status {
 	container, buildWarnings, err := srv.runtime.Create(&config, name)
 	if err != nil {
 		if srv.runtime.graph.IsNotExist(err) {
-			_, tag := utils.ParseRepositoryTag(config.Image)
+			_, tag := utils.ParseRepositoryRef(config.Image)
 			if tag == "" {
 				tag = DEFAULTTAG
 			}
@@ -1317,6 +2025,44 @@ func (srv *Server) ContainerCreate(job *engine.Job) engine.Status {
 	return engine.StatusOK
 }
 
+// ImageSquash flattens the layers of the image given as argument which are
+// above the ancestor named by the "from" env, or all of them if it is empty,
+// into a single new layer. The new image is tagged with the "repo" and "tag"
+// env if they are set, and its ID is printed.
+func (srv *Server) ImageSquash(job *engine.Job) engine.Status {
+	if len(job.Args) != 1 {
+		job.Errorf("Usage: %s IMAGE", job.Name)
+		return engine.StatusErr
+	}
+	name := job.Args[0]
+	img, err := srv.runtime.repositories.LookupImage(name)
+	if err != nil {
+		job.Errorf("No such image: %s", name)
+		return engine.StatusErr
+	}
+	var parent *Image
+	if from := job.Getenv("from"); from != "" {
+		if parent, err = srv.runtime.repositories.LookupImage(from); err != nil {
+			job.Errorf("No such image: %s", from)
+			return engine.StatusErr
+		}
+	}
+	squashed, err := srv.runtime.graph.Squash(img, parent)
+	if err != nil {
+		job.Error(err)
+		return engine.StatusErr
+	}
+	if repo := job.Getenv("repo"); repo != "" {
+		if err := srv.runtime.repositories.Set(repo, job.Getenv("tag"), squashed.ID, true); err != nil {
+			job.Error(err)
+			return engine.StatusErr
+		}
+	}
+	srv.LogEvent("squash", squashed.ID, srv.runtime.repositories.ImageName(img.ID))
+	job.Printf("%s\n", squashed.ID)
+	return engine.StatusOK
+}
+
 func (srv *Server) ContainerRestart(name string, t int) error {
 	if container := srv.runtime.Get(name); container != nil {
 		if err := container.Restart(t); err != nil {
@@ -1563,7 +2309,7 @@ func (srv *Server) ImageDelete(name string, autoPrune bool) ([]APIRmi, error) {
 		//delete via ID
 		return srv.deleteImage(img, "", "")
 	}
-	name, tag := utils.ParseRepositoryTag(name)
+	name, tag := utils.ParseRepositoryRef(name)
 	return srv.deleteImage(img, name, tag)
 }
 
@@ -1854,6 +2600,10 @@ func NewServer(eng *engine.Engine, config *DaemonConfig) (*Server, error) {
 		listeners:   make(map[string]chan utils.JSONMessage),
 		reqFactory:  nil,
 	}
+	registry.SetSecurity(config.InsecureRegistries, config.RegistryCertsDir, config.Mirrors)
+	for _, endpoint := range config.Mirrors {
+		srv.mirrors = append(srv.mirrors, registry.NewMirror(endpoint))
+	}
 	runtime.srv = srv
 	return srv, nil
 }
@@ -1905,5 +2655,6 @@ type Server struct {
 	events      []utils.JSONMessage
 	listeners   map[string]chan utils.JSONMessage
 	reqFactory  *utils.HTTPRequestFactory
+	mirrors     []*registry.Mirror
 	Eng         *engine.Engine
 }
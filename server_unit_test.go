@@ -1,7 +1,12 @@
 package docker
 
 import (
+	"github.com/dotcloud/docker/archive"
+	"github.com/dotcloud/docker/graphdriver"
 	"github.com/dotcloud/docker/utils"
+	"io"
+	"io/ioutil"
+	"os"
 	"testing"
 	"time"
 )
@@ -97,3 +102,71 @@ func setTimeout(t *testing.T, msg string, d time.Duration, f func()) {
 		t.Fatal(msg)
 	}
 }
+
+func TestRegisterLayerChecksum(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	driver, err := graphdriver.New(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer driver.Cleanup()
+	graph, err := NewGraph(tmp, driver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &Server{runtime: &Runtime{graph: graph}}
+
+	// Compute the checksum of the layer the way the registry does
+	layer, err := fakeTar()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tarsum := &utils.TarSum{Reader: layer}
+	if _, err := io.Copy(ioutil.Discard, tarsum); err != nil {
+		t.Fatal(err)
+	}
+
+	download := func(id string) *layerDownload {
+		layer, err := fakeTar()
+		if err != nil {
+			t.Fatal(err)
+		}
+		tmpLayer, err := archive.NewTempArchive(layer, tmp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		imgJSON := []byte(`{"id":"` + id + `"}`)
+		img, err := NewImgJSON(imgJSON)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &layerDownload{id: id, imgJSON: imgJSON, img: img, layer: tmpLayer}
+	}
+
+	d := download("foo")
+	if err := srv.registerLayer(d, tarsum.Sum(d.imgJSON)); err != nil {
+		t.Fatal(err)
+	}
+	if img, err := graph.Get("foo"); err != nil {
+		t.Fatal(err)
+	} else if img.Checksum != tarsum.Sum(d.imgJSON) {
+		t.Fatalf("Expected the checksum to be stored, got %q", img.Checksum)
+	}
+	if jsonData, err := ioutil.ReadFile(jsonPath(graph.imageRoot("foo"))); err != nil {
+		t.Fatal(err)
+	} else if string(jsonData) != string(d.imgJSON) {
+		t.Fatalf("Expected the json the checksum covers to be stored, got %s", jsonData)
+	}
+
+	d = download("bar")
+	if err := srv.registerLayer(d, "tarsum+sha256:0000"); err == nil {
+		t.Fatal("Expected a checksum mismatch")
+	}
+	if graph.Exists("bar") {
+		t.Fatal("Expected the corrupted layer to be removed")
+	}
+}
@@ -81,3 +81,27 @@ func sortContainers(containers []*Container, predicate func(i, j *Container) boo
 	s := &containerSorter{containers, predicate}
 	sort.Sort(s)
 }
+
+type changeSorter struct {
+	changes []APIChange
+	by      func(i, j *APIChange) bool
+}
+
+func (s *changeSorter) Len() int {
+	return len(s.changes)
+}
+
+func (s *changeSorter) Swap(i, j int) {
+	s.changes[i], s.changes[j] = s.changes[j], s.changes[i]
+}
+
+func (s *changeSorter) Less(i, j int) bool {
+	return s.by(&s.changes[i], &s.changes[j])
+}
+
+func sortChangesByPath(changes []APIChange) {
+	byPath := func(i, j *APIChange) bool {
+		return i.Path < j.Path
+	}
+	sort.Sort(&changeSorter{changes, byPath})
+}
@@ -3,9 +3,11 @@ package docker
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/dotcloud/docker/registry"
 	"github.com/dotcloud/docker/utils"
 	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
 	"strings"
@@ -17,6 +19,8 @@ type TagStore struct {
 	path         string
 	graph        *Graph
 	Repositories map[string]Repository
+	// The images pulled or pushed by digest, by repository and by digest
+	Digests map[string]Repository
 }
 
 type Repository map[string]string
@@ -30,6 +34,7 @@ func NewTagStore(path string, graph *Graph) (*TagStore, error) {
 		path:         abspath,
 		graph:        graph,
 		Repositories: make(map[string]Repository),
+		Digests:      make(map[string]Repository),
 	}
 	// Load the json file if it exists, otherwise create it.
 	if err := store.Reload(); os.IsNotExist(err) {
@@ -70,7 +75,7 @@ func (store *TagStore) LookupImage(name string) (*Image, error) {
 	if err != nil {
 		// FIXME: standardize on returning nil when the image doesn't exist, and err for everything else
 		// (so we can pass all errors here)
-		repos, tag := utils.ParseRepositoryTag(name)
+		repos, tag := utils.ParseRepositoryRef(name)
 		if tag == "" {
 			tag = DEFAULTTAG
 		}
@@ -110,7 +115,25 @@ func (store *TagStore) ImageName(id string) string {
 	return utils.TruncateID(id)
 }
 
+// ByDigest returns the digests referring to each image, as repo@digest.
+func (store *TagStore) ByDigest() map[string][]string {
+	byDigest := make(map[string][]string)
+	for repoName, digests := range store.Digests {
+		for digest, id := range digests {
+			byDigest[id] = append(byDigest[id], repoName+"@"+digest)
+			sort.Strings(byDigest[id])
+		}
+	}
+	return byDigest
+}
+
 func (store *TagStore) DeleteAll(id string) error {
+	for _, name := range store.ByDigest()[id] {
+		repoName, digest := utils.ParseRepositoryRef(name)
+		if _, err := store.Delete(repoName, digest); err != nil {
+			return err
+		}
+	}
 	names, exists := store.ByID()[id]
 	if !exists || len(names) == 0 {
 		return nil
@@ -135,6 +158,16 @@ func (store *TagStore) Delete(repoName, tag string) (bool, error) {
 	if err := store.Reload(); err != nil {
 		return false, err
 	}
+	if utils.IsDigest(tag) {
+		if _, exists := store.Digests[repoName][tag]; !exists {
+			return false, fmt.Errorf("No such digest: %s@%s", repoName, tag)
+		}
+		delete(store.Digests[repoName], tag)
+		if len(store.Digests[repoName]) == 0 {
+			delete(store.Digests, repoName)
+		}
+		return true, store.Save()
+	}
 	if r, exists := store.Repositories[repoName]; exists {
 		if tag != "" {
 			if _, exists2 := r[tag]; exists2 {
@@ -170,6 +203,11 @@ func (store *TagStore) Set(repoName, tag, imageName string, force bool) error {
 	if err := validateTagName(tag); err != nil {
 		return err
 	}
+	if registry.IsDirEndpoint(repoName) {
+		// Keep "dir:///mnt/images/app" as "dir:/mnt/images/app", the name a
+		// pull gives it, which can be part of the remote api urls
+		repoName = path.Clean(repoName)
+	}
 	if err := store.Reload(); err != nil {
 		return err
 	}
@@ -178,15 +216,38 @@ func (store *TagStore) Set(repoName, tag, imageName string, force bool) error {
 		repo = r
 	} else {
 		repo = make(map[string]string)
-		if old, exists := store.Repositories[repoName]; exists && !force {
-			return fmt.Errorf("Conflict: Tag %s:%s is already set to %s", repoName, tag, old)
-		}
 		store.Repositories[repoName] = repo
 	}
 	repo[tag] = img.ID
 	return store.Save()
 }
 
+// SetDigest records that digest, the digest of a manifest of repoName,
+// refers to imageName.
+func (store *TagStore) SetDigest(repoName, digest, imageName string) error {
+	img, err := store.LookupImage(imageName)
+	if err != nil {
+		return err
+	}
+	if !utils.IsDigest(digest) {
+		return fmt.Errorf("Illegal digest: %s", digest)
+	}
+	if err := validateRepoName(repoName); err != nil {
+		return err
+	}
+	if err := store.Reload(); err != nil {
+		return err
+	}
+	if store.Digests == nil {
+		store.Digests = make(map[string]Repository)
+	}
+	if _, exists := store.Digests[repoName]; !exists {
+		store.Digests[repoName] = make(map[string]string)
+	}
+	store.Digests[repoName][digest] = img.ID
+	return store.Save()
+}
+
 func (store *TagStore) Get(repoName string) (Repository, error) {
 	if err := store.Reload(); err != nil {
 		return nil, err
@@ -198,6 +259,15 @@ func (store *TagStore) Get(repoName string) (Repository, error) {
 }
 
 func (store *TagStore) GetImage(repoName, tagOrID string) (*Image, error) {
+	if utils.IsDigest(tagOrID) {
+		if err := store.Reload(); err != nil {
+			return nil, err
+		}
+		if id, exists := store.Digests[repoName][tagOrID]; exists {
+			return store.graph.Get(id)
+		}
+		return nil, nil
+	}
 	repo, err := store.Get(repoName)
 	if err != nil {
 		return nil, err
@@ -84,3 +84,73 @@ func TestLookupImage(t *testing.T) {
 		t.Errorf("Expected 1 image, none found")
 	}
 }
+
+func TestSetMovesTag(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	store := mkTestTagStore(tmp, t)
+	defer store.graph.driver.Cleanup()
+
+	archive, err := fakeTar()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.graph.Register(nil, archive, &Image{ID: "bar"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// docker tag and commit -t move an existing tag, force or not
+	if err := store.Set(testImageName, DEFAULTTAG, "bar", false); err != nil {
+		t.Fatal(err)
+	}
+	if img, err := store.LookupImage(testImageName); err != nil || img.ID != "bar" {
+		t.Fatalf("Expected %s to point to bar", testImageName)
+	}
+}
+func TestDigestReferences(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	store := mkTestTagStore(tmp, t)
+	defer store.graph.driver.Cleanup()
+
+	digest := utils.Digest([]byte("manifest"))
+	if err := store.SetDigest(testImageName, "latest", testImageID); err == nil {
+		t.Fatal("Expected a tag to be refused as digest")
+	}
+	if err := store.SetDigest(testImageName, digest, testImageID); err != nil {
+		t.Fatal(err)
+	}
+	if img, err := store.LookupImage(testImageName + "@" + digest); err != nil {
+		t.Fatal(err)
+	} else if img.ID != testImageID {
+		t.Fatalf("Expected %s@%s to point to %s, got %s", testImageName, digest, testImageID, img.ID)
+	}
+	if _, err := store.LookupImage(testImageName + "@" + utils.Digest([]byte("other"))); err == nil {
+		t.Fatal("Expected error looking up an unknown digest")
+	}
+	if names := store.ByDigest()[testImageID]; len(names) != 1 || names[0] != testImageName+"@"+digest {
+		t.Fatalf("Expected %s@%s to refer to %s, got %v", testImageName, digest, testImageID, names)
+	}
+
+	// The digests are kept with the tags
+	reloaded, err := NewTagStore(path.Join(tmp, "tags"), store.graph)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img, err := reloaded.LookupImage(testImageName + "@" + digest); err != nil || img.ID != testImageID {
+		t.Fatalf("Expected the digest to be saved, got %v", err)
+	}
+
+	if err := store.DeleteAll(testImageID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.LookupImage(testImageName + "@" + digest); err == nil {
+		t.Fatal("Expected the digest to be deleted with the image")
+	}
+}
@@ -62,7 +62,9 @@ func CompareConfig(a, b *Config) bool {
 		len(a.PortSpecs) != len(b.PortSpecs) ||
 		len(a.ExposedPorts) != len(b.ExposedPorts) ||
 		len(a.Entrypoint) != len(b.Entrypoint) ||
-		len(a.Volumes) != len(b.Volumes) {
+		len(a.Volumes) != len(b.Volumes) ||
+		len(a.Labels) != len(b.Labels) ||
+		len(a.OnBuild) != len(b.OnBuild) {
 		return false
 	}
 
@@ -101,6 +103,16 @@ func CompareConfig(a, b *Config) bool {
 			return false
 		}
 	}
+	for key, value := range a.Labels {
+		if v, exists := b.Labels[key]; !exists || v != value {
+			return false
+		}
+	}
+	for i := 0; i < len(a.OnBuild); i++ {
+		if a.OnBuild[i] != b.OnBuild[i] {
+			return false
+		}
+	}
 	return true
 }
 
@@ -212,9 +224,43 @@ func MergeConfig(userConf, imageConf *Config) error {
 			userConf.Volumes[k] = v
 		}
 	}
+	if userConf.Labels == nil || len(userConf.Labels) == 0 {
+		userConf.Labels = imageConf.Labels
+	} else {
+		for k, v := range imageConf.Labels {
+			if _, exists := userConf.Labels[k]; !exists {
+				userConf.Labels[k] = v
+			}
+		}
+	}
 	return nil
 }
 
+// Labels come in the format of key=value, the value being optional
+func parseLabel(rawLabel string) (string, string) {
+	parts := strings.SplitN(rawLabel, "=", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+// matchLabels returns true if `labels` satisfies every filter. A filter
+// is either `key`, matching any value, or `key=value`.
+func matchLabels(labels map[string]string, filters []string) bool {
+	for _, filter := range filters {
+		key, value := parseLabel(filter)
+		v, exists := labels[key]
+		if !exists {
+			return false
+		}
+		if strings.Contains(filter, "=") && v != value {
+			return false
+		}
+	}
+	return true
+}
+
 func parseLxcConfOpts(opts ListOpts) ([]KeyValuePair, error) {
 	out := make([]KeyValuePair, opts.Len())
 	for i, o := range opts.GetAll() {
@@ -1,8 +1,10 @@
 package utils
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"syscall"
 )
 
@@ -33,3 +35,51 @@ func TreeSize(dir string) (size int64, err error) {
 	})
 	return
 }
+
+// maxSymlinks is the number of symlinks FollowSymlinkInScope follows before
+// giving up on a loop
+const maxSymlinks = 255
+
+// FollowSymlinkInScope resolves the symlinks of the path `name` in the
+// directory `root`, one component at a time, as if `root` was the root of
+// the filesystem: absolute symlinks are relative to `root`, and neither
+// they nor ".." can lead out of it. All the components must exist.
+func FollowSymlinkInScope(root, name string) (string, error) {
+	var (
+		current = "/"
+		rest    = strings.Split(name, "/")
+		links   = 0
+	)
+	for len(rest) > 0 {
+		part := rest[0]
+		rest = rest[1:]
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			current = filepath.Dir(current)
+			continue
+		}
+		next := filepath.Join(current, part)
+		fi, err := os.Lstat(filepath.Join(root, next))
+		if err != nil {
+			return "", err
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			current = next
+			continue
+		}
+		if links++; links > maxSymlinks {
+			return "", fmt.Errorf("Too many levels of symbolic links: %s", name)
+		}
+		target, err := os.Readlink(filepath.Join(root, next))
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) {
+			current = "/"
+		}
+		rest = append(strings.Split(target, "/"), rest...)
+	}
+	return filepath.Join(root, current), nil
+}
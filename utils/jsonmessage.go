@@ -65,15 +65,29 @@ func (p *JSONProgress) String() string {
 	return pbBox + numbersBox + timeLeftBox
 }
 
+// JSONBuildEvent is a step of a build, for the tools following it.
+type JSONBuildEvent struct {
+	Event       string  `json:"event"` // step, cache-hit, cache-miss, container, step-done or error
+	Step        int     `json:"step,omitempty"`
+	Line        int     `json:"line,omitempty"`
+	Instruction string  `json:"instruction,omitempty"`
+	ContainerID string  `json:"containerId,omitempty"`
+	ImageID     string  `json:"imageId,omitempty"`
+	Duration    float64 `json:"duration,omitempty"` // in seconds
+	Error       string  `json:"error,omitempty"`
+	Code        int     `json:"code,omitempty"`
+}
+
 type JSONMessage struct {
-	Status          string        `json:"status,omitempty"`
-	Progress        *JSONProgress `json:"progressDetail,omitempty"`
-	ProgressMessage string        `json:"progress,omitempty"` //deprecated
-	ID              string        `json:"id,omitempty"`
-	From            string        `json:"from,omitempty"`
-	Time            int64         `json:"time,omitempty"`
-	Error           *JSONError    `json:"errorDetail,omitempty"`
-	ErrorMessage    string        `json:"error,omitempty"` //deprecated
+	Status          string          `json:"status,omitempty"`
+	Progress        *JSONProgress   `json:"progressDetail,omitempty"`
+	ProgressMessage string          `json:"progress,omitempty"` //deprecated
+	ID              string          `json:"id,omitempty"`
+	From            string          `json:"from,omitempty"`
+	Time            int64           `json:"time,omitempty"`
+	Error           *JSONError      `json:"errorDetail,omitempty"`
+	ErrorMessage    string          `json:"error,omitempty"` //deprecated
+	BuildEvent      *JSONBuildEvent `json:"buildEvent,omitempty"`
 }
 
 func (jm *JSONMessage) Display(out io.Writer, isTerminal bool) error {
@@ -83,6 +97,10 @@ func (jm *JSONMessage) Display(out io.Writer, isTerminal bool) error {
 		}
 		return jm.Error
 	}
+	// The build events repeat the text of the build
+	if jm.BuildEvent != nil && jm.Status == "" {
+		return nil
+	}
 	var endl string
 	if isTerminal {
 		// <ESC>[2K = erase entire current line
@@ -154,3 +172,29 @@ func DisplayJSONMessagesStream(in io.Reader, out io.Writer, terminalFd uintptr,
 	}
 	return nil
 }
+
+// DisplayJSONBuildEvents prints the build events of a stream of messages,
+// one json object per line, and returns the error ending the stream if any.
+func DisplayJSONBuildEvents(in io.Reader, out io.Writer) error {
+	var (
+		dec = json.NewDecoder(in)
+		enc = json.NewEncoder(out)
+	)
+	for {
+		var jm JSONMessage
+		if err := dec.Decode(&jm); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if jm.Error != nil {
+			return jm.Error
+		}
+		if jm.BuildEvent != nil {
+			if err := enc.Encode(jm.BuildEvent); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package utils
 
 import (
+	"bytes"
+	"strings"
 	"testing"
 )
 
@@ -27,3 +29,31 @@ func TestProgress(t *testing.T) {
 		t.Fatalf("Expected '[=========================>                         ]     50 B/100 B', got '%s'", jp3.String())
 	}
 }
+
+func TestDisplayJSONBuildEvents(t *testing.T) {
+	stream := `{"status":"Step 1 : FROM busybox"}
+{"buildEvent":{"event":"step","step":1,"line":1,"instruction":"FROM busybox"}}
+{"buildEvent":{"event":"step-done","step":1,"imageId":"e9aa60c60128","duration":0.5}}
+{"buildEvent":{"event":"error","step":2,"error":"failed","code":23}}
+{"errorDetail":{"code":23,"message":"failed"},"error":"failed"}
+`
+	out := bytes.NewBuffer(nil)
+	err := DisplayJSONBuildEvents(strings.NewReader(stream), out)
+	if jsonErr, ok := err.(*JSONError); !ok || jsonErr.Code != 23 {
+		t.Fatalf("Expected the error of the build, got %v", err)
+	}
+	expected := `{"event":"step","step":1,"line":1,"instruction":"FROM busybox"}
+{"event":"step-done","step":1,"imageId":"e9aa60c60128","duration":0.5}
+{"event":"error","step":2,"error":"failed","code":23}
+`
+	if out.String() != expected {
+		t.Fatalf("Expected %s, got %s", expected, out.String())
+	}
+
+	// The text display skips the events
+	out.Reset()
+	DisplayJSONMessagesStream(strings.NewReader(stream), out, 0, false)
+	if out.String() != "Step 1 : FROM busybox" {
+		t.Fatalf("Expected only the text of the build, got %q", out.String())
+	}
+}
@@ -67,6 +67,20 @@ func (sf *StreamFormatter) FormatProgress(id, action string, progress *JSONProgr
 	return []byte(action + " " + progress.String() + endl)
 }
 
+// FormatBuildEvent returns the message of a build event, which is only
+// sent in json.
+func (sf *StreamFormatter) FormatBuildEvent(event *JSONBuildEvent) []byte {
+	if !sf.json {
+		return nil
+	}
+	sf.used = true
+	b, err := json.Marshal(&JSONMessage{BuildEvent: event})
+	if err != nil {
+		return sf.FormatError(err)
+	}
+	return b
+}
+
 func (sf *StreamFormatter) Used() bool {
 	return sf.used
 }
@@ -23,6 +23,11 @@ func (h verboseHash) Write(buf []byte) (int, error) {
 
 type TarSum struct {
 	io.Reader
+
+	// Leave the modification times out of the checksum, for files whose
+	// content only matters, like a build context
+	IgnoreMtime bool
+
 	tarR     *tar.Reader
 	tarW     *tar.Writer
 	gz       *gzip.Writer
@@ -52,6 +57,9 @@ func (ts *TarSum) encodeHeader(h *tar.Header) error {
 		// {"atime", strconv.Itoa(int(h.AccessTime.UTC().Unix()))},
 		// {"ctime", strconv.Itoa(int(h.ChangeTime.UTC().Unix()))},
 	} {
+		if ts.IgnoreMtime && elem[0] == "mtime" {
+			continue
+		}
 		//		Debugf("-->%s<-- -->%s<--", elem[0], elem[1])
 		if _, err := ts.h.Write([]byte(elem[0] + elem[1])); err != nil {
 			return err
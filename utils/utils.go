@@ -711,7 +711,76 @@ func IsURL(str string) bool {
 }
 
 func IsGIT(str string) bool {
-	return strings.HasPrefix(str, "git://") || strings.HasPrefix(str, "github.com/")
+	url, _, _ := ParseGitURL(str)
+	return strings.HasPrefix(str, "git://") || strings.HasPrefix(str, "github.com/") || strings.HasPrefix(str, "git@") ||
+		(IsURL(str) && strings.HasSuffix(url, ".git"))
+}
+
+// ParseGitURL splits a git remote of the form URL#ref:subdir. The ref and
+// the subdirectory are both optional.
+func ParseGitURL(str string) (url, ref, subdir string) {
+	url = str
+	if i := strings.Index(str, "#"); i >= 0 {
+		url, ref = str[:i], str[i+1:]
+		if i := strings.Index(ref, ":"); i >= 0 {
+			ref, subdir = ref[:i], ref[i+1:]
+		}
+	}
+	return
+}
+
+var gitCommitSHA = regexp.MustCompile(`^[0-9a-fA-F]{7,40}$`)
+
+// GitClone checks out the `ref` of the git repository `remoteURL` with its
+// submodules in the directory `dir`, and returns the SHA of the commit. Only
+// this commit is fetched, unless the remote refuses to send a commit by its
+// SHA. The default branch is checked out if `ref` is empty.
+func GitClone(remoteURL, ref, dir string) (string, error) {
+	if strings.HasPrefix(ref, "-") {
+		return "", fmt.Errorf("Invalid git ref %s", ref)
+	}
+	git := func(args ...string) ([]byte, error) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			return nil, fmt.Errorf("Error trying to use git: %s (%s)", err, bytes.TrimSpace(output))
+		}
+		return output, nil
+	}
+
+	if _, err := git("init", "-q"); err != nil {
+		return "", err
+	}
+	if _, err := git("remote", "add", "origin", "--", remoteURL); err != nil {
+		return "", err
+	}
+	fetchRef := ref
+	if fetchRef == "" {
+		fetchRef = "HEAD"
+	}
+	checkoutRef := "FETCH_HEAD"
+	if _, err := git("fetch", "-q", "--depth", "1", "--", "origin", fetchRef); err != nil {
+		if !gitCommitSHA.MatchString(ref) {
+			return "", err
+		}
+		// Look for the commit in the history of all the branches
+		if _, err := git("fetch", "-q", "--", "origin"); err != nil {
+			return "", err
+		}
+		checkoutRef = ref
+	}
+	if _, err := git("checkout", "-q", checkoutRef, "--"); err != nil {
+		return "", err
+	}
+	if _, err := git("submodule", "-q", "update", "--init", "--recursive"); err != nil {
+		return "", err
+	}
+	output, err := git("rev-parse", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	return string(bytes.TrimSpace(output)), nil
 }
 
 // GetResolvConf opens and read the content of /etc/resolv.conf.
@@ -824,6 +893,22 @@ func GetReleaseVersion() string {
 	return strings.TrimSpace(string(body))
 }
 
+const digestPrefix = "sha256:"
+
+var validDigest = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
+// Digest returns the content address of data: "sha256:" followed by the
+// hex encoded sha256 of data.
+func Digest(data []byte) string {
+	h := sha256.Sum256(data)
+	return digestPrefix + hex.EncodeToString(h[:])
+}
+
+// IsDigest returns true if ref is a digest rather than a tag.
+func IsDigest(ref string) bool {
+	return validDigest.MatchString(ref)
+}
+
 // Get a repos name and returns the right reposName + tag
 // The tag can be confusing because of a port in a repository name.
 //     Ex: localhost.localdomain:5000/samalba/hipache:latest
@@ -838,6 +923,17 @@ func ParseRepositoryTag(repos string) (string, string) {
 	return repos, ""
 }
 
+// ParseRepositoryRef is ParseRepositoryTag for the names which may refer to
+// an image by digest instead of by tag, in which case the digest is
+// returned as the tag.
+//     Ex: samalba/hipache@sha256:<hex>
+func ParseRepositoryRef(repos string) (string, string) {
+	if n := strings.Index(repos, "@"); n >= 0 {
+		return repos[:n], repos[n+1:]
+	}
+	return ParseRepositoryTag(repos)
+}
+
 type User struct {
 	Uid      string // user id
 	Gid      string // primary group id
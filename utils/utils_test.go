@@ -3,10 +3,16 @@ package utils
 import (
 	"bytes"
 	"errors"
+	"fmt"
+	"github.com/dotcloud/tar"
 	"io"
 	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestBufReader(t *testing.T) {
@@ -340,6 +346,31 @@ func TestParseRepositoryTag(t *testing.T) {
 	}
 }
 
+func TestParseRepositoryRef(t *testing.T) {
+	digest := Digest([]byte("manifest"))
+	if repo, tag := ParseRepositoryRef("url:5000/repo@" + digest); repo != "url:5000/repo" || tag != digest {
+		t.Errorf("Expected repo: '%s' and tag: '%s', got '%s' and '%s'", "url:5000/repo", digest, repo, tag)
+	}
+	if repo, tag := ParseRepositoryRef("url:5000/repo:tag"); repo != "url:5000/repo" || tag != "tag" {
+		t.Errorf("Expected repo: '%s' and tag: '%s', got '%s' and '%s'", "url:5000/repo", "tag", repo, tag)
+	}
+}
+
+func TestDigest(t *testing.T) {
+	digest := Digest([]byte("manifest"))
+	if !IsDigest(digest) {
+		t.Fatalf("Expected %s to be a digest", digest)
+	}
+	if digest != Digest([]byte("manifest")) || digest == Digest([]byte("manifest2")) {
+		t.Fatal("Expected the digest to depend on the content only")
+	}
+	for _, ref := range []string{"latest", "sha256:abc", "md5:" + strings.Repeat("a", 64)} {
+		if IsDigest(ref) {
+			t.Fatalf("Expected %s not to be a digest", ref)
+		}
+	}
+}
+
 func TestGetResolvConf(t *testing.T) {
 	resolvConfUtils, err := GetResolvConf()
 	if err != nil {
@@ -515,3 +546,209 @@ func StrSlicesEqual(a, b []string) bool {
 
 	return true
 }
+
+func TestParseGitURL(t *testing.T) {
+	for remote, expected := range map[string][3]string{
+		"github.com/dotcloud/docker":                 {"github.com/dotcloud/docker", "", ""},
+		"github.com/dotcloud/docker#v0.8.0":          {"github.com/dotcloud/docker", "v0.8.0", ""},
+		"github.com/dotcloud/docker#master:contrib":  {"github.com/dotcloud/docker", "master", "contrib"},
+		"git://github.com/dotcloud/docker.git#:docs": {"git://github.com/dotcloud/docker.git", "", "docs"},
+	} {
+		url, ref, subdir := ParseGitURL(remote)
+		if url != expected[0] || ref != expected[1] || subdir != expected[2] {
+			t.Errorf("Expected %v for %s, got [%s %s %s]", expected, remote, url, ref, subdir)
+		}
+	}
+
+	for remote, expected := range map[string]bool{
+		"git://github.com/dotcloud/docker":              true,
+		"github.com/dotcloud/docker#master":             true,
+		"git@github.com:dotcloud/docker.git":            true,
+		"https://github.com/dotcloud/docker.git#v0.8.0": true,
+		"https://github.com/dotcloud/docker":            false,
+		"http://example.com/Dockerfile":                 false,
+	} {
+		if IsGIT(remote) != expected {
+			t.Errorf("Expected IsGIT(%s) to be %v", remote, expected)
+		}
+	}
+}
+
+func TestGitClone(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "docker-test-git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	// The submodule is cloned from the disk
+	for key, value := range map[string]string{
+		"GIT_CONFIG_COUNT":   "1",
+		"GIT_CONFIG_KEY_0":   "protocol.file.allow",
+		"GIT_CONFIG_VALUE_0": "always",
+	} {
+		os.Setenv(key, value)
+		defer os.Unsetenv(key)
+	}
+
+	git := func(dir string, args ...string) string {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@docker.io"}, args...)...)
+		cmd.Dir = dir
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %s (%s)", args, err, output)
+		}
+		return strings.TrimSpace(string(output))
+	}
+	commit := func(dir, file, content string) string {
+		if err := ioutil.WriteFile(filepath.Join(dir, file), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		git(dir, "add", "-A")
+		git(dir, "commit", "-q", "-m", content)
+		return git(dir, "rev-parse", "HEAD")
+	}
+
+	sub := filepath.Join(tmp, "sub")
+	repo := filepath.Join(tmp, "repo")
+	for _, dir := range []string{sub, repo} {
+		if err := os.Mkdir(dir, 0700); err != nil {
+			t.Fatal(err)
+		}
+		git(dir, "init", "-q")
+	}
+	commit(sub, "file", "submodule")
+	git(repo, "submodule", "-q", "add", "file://"+sub, "sub")
+	first := commit(repo, "file", "first")
+	git(repo, "tag", "v1")
+	git(repo, "checkout", "-q", "-b", "branch")
+	branch := commit(repo, "file", "branch")
+	git(repo, "checkout", "-q", "-")
+	last := commit(repo, "file", "last")
+
+	for i, c := range []struct {
+		ref, commit, content string
+	}{
+		{"", last, "last"},
+		{"v1", first, "first"},
+		{"branch", branch, "branch"},
+		{first, first, "first"},
+		{first[:10], first, "first"},
+	} {
+		dir := filepath.Join(tmp, fmt.Sprintf("clone%d", i))
+		if err := os.Mkdir(dir, 0700); err != nil {
+			t.Fatal(err)
+		}
+		sha, err := GitClone("file://"+repo, c.ref, dir)
+		if err != nil {
+			t.Fatalf("Cloning %q: %s", c.ref, err)
+		}
+		if sha != c.commit {
+			t.Errorf("Expected the commit %s for %q, got %s", c.commit, c.ref, sha)
+		}
+		if content, err := ioutil.ReadFile(filepath.Join(dir, "file")); err != nil || string(content) != c.content {
+			t.Errorf("Expected %q in the clone of %q, got %q (%v)", c.content, c.ref, content, err)
+		}
+		if content, err := ioutil.ReadFile(filepath.Join(dir, "sub", "file")); err != nil || string(content) != "submodule" {
+			t.Errorf("Expected the submodule in the clone of %q, got %q (%v)", c.ref, content, err)
+		}
+	}
+
+	dir := filepath.Join(tmp, "missing")
+	if err := os.Mkdir(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GitClone("file://"+repo, "missing", dir); err == nil {
+		t.Fatal("Expected an error for a missing ref")
+	}
+	if _, err := GitClone("file://"+repo, "--upload-pack=touch "+filepath.Join(tmp, "injected"), dir); err == nil {
+		t.Fatal("Expected an error for a ref starting with -")
+	}
+	if _, err := os.Stat(filepath.Join(tmp, "injected")); err == nil {
+		t.Fatal("Expected the ref not to be used as an option")
+	}
+}
+
+func TestTarSumIgnoreMtime(t *testing.T) {
+	sum := func(mtime time.Time, ignoreMtime bool) string {
+		buf := &bytes.Buffer{}
+		tw := tar.NewWriter(buf)
+		if err := tw.WriteHeader(&tar.Header{Name: "file", Mode: 0600, Size: 5, ModTime: mtime}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		tarsum := &TarSum{Reader: buf, IgnoreMtime: ignoreMtime}
+		if _, err := io.Copy(ioutil.Discard, tarsum); err != nil {
+			t.Fatal(err)
+		}
+		return tarsum.Sum(nil)
+	}
+	old, now := time.Unix(1000, 0), time.Now()
+	if sum(old, false) == sum(now, false) {
+		t.Fatal("Expected the modification time to change the checksum")
+	}
+	if sum(old, true) != sum(now, true) {
+		t.Fatal("Expected the modification time to be ignored")
+	}
+}
+
+func TestFollowSymlinkInScope(t *testing.T) {
+	root, err := ioutil.TempDir("", "docker-test-symlink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	for _, dir := range []string{"run/lock", "usr/share/zoneinfo"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "usr/share/zoneinfo/UTC"), []byte("UTC"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for link, target := range map[string]string{
+		"var":          "usr/../run/..",
+		"run/var":      "/run",
+		"localtime":    "/usr/share/zoneinfo/UTC",
+		"escape":       "../../../../..",
+		"loop":         "loop",
+		"dangling":     "/missing",
+		"usr/relative": "share/zoneinfo",
+	} {
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, expected := range map[string]string{
+		"/":                 "/",
+		"/run/var/lock":     "/run/lock",
+		"/localtime":        "/usr/share/zoneinfo/UTC",
+		"/escape/etc":       "",
+		"/escape":           "/",
+		"../../run":         "/run",
+		"/usr/relative/UTC": "/usr/share/zoneinfo/UTC",
+		"/var/run":          "/run",
+		"/loop":             "",
+		"/dangling":         "",
+	} {
+		resolved, err := FollowSymlinkInScope(root, name)
+		if expected == "" {
+			if err == nil {
+				t.Errorf("Expected an error resolving %s, got %s", name, resolved)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Resolving %s: %s", name, err)
+		} else if resolved != filepath.Join(root, expected) {
+			t.Errorf("Expected %s to resolve to %s, got %s", name, expected, resolved)
+		}
+	}
+}